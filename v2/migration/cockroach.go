@@ -0,0 +1,150 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterDialect(DBDriverCockroach.String(), &cockroachDialect{})
+}
+
+// cockroachDialect is the Postgres dialect with the CockroachDB types and
+// defaults. CockroachDB has no uuid-ossp extension nor sequences backing
+// SERIAL columns, and runs schema changes asynchronously so they cannot be
+// rolled back with the transaction.
+type cockroachDialect struct {
+	postgresDialect
+}
+
+func (d *cockroachDialect) Name() string {
+	return DBDriverCockroach.String()
+}
+
+func (d *cockroachDialect) Types() *TypeRegistry {
+	return cockroachTypes
+}
+
+func (d *cockroachDialect) FormatDefault(column *Column) string {
+	if !isTextType(column.Type) && strings.Contains(column.Default, "uuid") {
+		return "gen_random_uuid()"
+	}
+
+	return d.postgresDialect.FormatDefault(column)
+}
+
+// TransactionalDDL returns false, CockroachDB requires schema changes outside
+// explicit transactions
+func (d *cockroachDialect) TransactionalDDL() bool {
+	return false
+}
+
+// columnDefinition returns the full definition of the column used by CREATE
+// TABLE and ADD COLUMN, auto incremented columns are generated by unique_rowid()
+func (d *cockroachDialect) columnDefinition(column *Column) string {
+	definition := column.Type
+	if column.NotNull || column.PrimaryKey {
+		definition += " NOT NULL"
+	}
+	if column.HasDefault {
+		definition += " DEFAULT " + column.Default
+	} else if column.AutoIncrement {
+		definition += " DEFAULT unique_rowid()"
+	}
+
+	return definition
+}
+
+func (d *cockroachDialect) CreateTable(table *Table) string {
+	return createTable(table, d.columnDefinition)
+}
+
+func (d *cockroachDialect) AddColumn(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD COLUMN %s %s;",
+		table,
+		column.Name,
+		d.columnDefinition(column),
+	)
+}
+
+// RenameIndex uses the table@index form, index names are only unique within
+// their table
+func (d *cockroachDialect) RenameIndex(table, from, to string) string {
+	return fmt.Sprintf(
+		"ALTER INDEX %s@%s RENAME TO %s;",
+		table,
+		from,
+		to,
+	)
+}
+
+func (d *cockroachDialect) DropIndex(table, index string) string {
+	return fmt.Sprintf("DROP INDEX %s@%s;", table, index)
+}
+
+// DropUnique drops the index of the unique constraint, CockroachDB cannot
+// drop it as a constraint
+func (d *cockroachDialect) DropUnique(table, name string) string {
+	return fmt.Sprintf("DROP INDEX %s@%s CASCADE;", table, name)
+}
+
+// cockroachColumnsQuery is the Postgres columns query without the hidden
+// rowid column of the tables without primary key
+const cockroachColumnsQuery = `SELECT c.table_name, c.column_name, c.data_type, COALESCE(c.character_maximum_length, c.datetime_precision), c.column_default,
+				c.is_nullable, EXISTS (
+					SELECT 1 FROM information_schema.table_constraints tc
+					JOIN information_schema.key_column_usage kcu
+						ON kcu.constraint_name = tc.constraint_name
+						AND kcu.table_schema = tc.table_schema
+						AND kcu.table_name = tc.table_name
+					WHERE tc.table_schema = c.table_schema AND tc.table_name = c.table_name
+						AND tc.constraint_type IN ('UNIQUE', 'PRIMARY KEY') AND kcu.column_name = c.column_name
+				)
+				FROM information_schema.columns c
+				WHERE c.table_schema = current_schema() AND c.is_hidden = 'NO'
+				ORDER BY c.table_name, c.ordinal_position;`
+
+// cockroachIndexesQuery reads the indexes from information_schema.statistics,
+// without the primary key columns CockroachDB stores in every index
+const cockroachIndexesQuery = `SELECT s.table_name, s.index_name, s.non_unique = 'NO', tc.constraint_name IS NOT NULL, s.column_name
+				FROM information_schema.statistics s
+				JOIN information_schema.columns c
+					ON c.table_schema = s.table_schema AND c.table_name = s.table_name
+					AND c.column_name = s.column_name AND c.is_hidden = 'NO'
+				LEFT JOIN information_schema.table_constraints tc
+					ON tc.table_schema = s.table_schema AND tc.table_name = s.table_name
+					AND tc.constraint_name = s.index_name AND tc.constraint_type = 'PRIMARY KEY'
+				WHERE s.table_schema = current_schema() AND s.storing = 'NO' AND s.implicit = 'NO'
+				ORDER BY s.table_name, s.index_name, s.seq_in_index;`
+
+var cockroachDefaultCast = regexp.MustCompile(`(?i):::[a-z0-9 ]+(\(\d+(,\d+)?\))?(\[\])?$`)
+
+// cockroachIntTypes are the CockroachDB names of the integer types reported
+// by information_schema
+var cockroachIntTypes = map[string]string{
+	"SMALLINT": "INT2",
+	"INT":      "INT4",
+	"BIGINT":   "INT8",
+}
+
+// normalizeCockroachColumn fills the column from its information_schema
+// description, CockroachDB reports INT8 columns as bigint and adds ::: type
+// annotations to the default expressions
+func normalizeCockroachColumn(column *Column, dataType, nullable string, length sql.NullInt64, defaultValue sql.NullString) {
+	if defaultValue.Valid {
+		defaultValue.String = cockroachDefaultCast.ReplaceAllString(defaultValue.String, "")
+	}
+	normalizePostgresColumn(column, dataType, nullable, length, defaultValue)
+	if intType, ok := cockroachIntTypes[column.Type]; ok {
+		column.Type = intType
+	}
+	column.AutoIncrement = column.Default == "unique_rowid()"
+}
+
+func (d *cockroachDialect) Inspect(ctx context.Context, q Queryer) (*Schema, error) {
+	return inspectPostgres(ctx, q, cockroachColumnsQuery, cockroachIndexesQuery, normalizeCockroachColumn)
+}
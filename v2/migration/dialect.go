@@ -0,0 +1,174 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Queryer is implemented by *sql.DB and *sql.Tx.
+type Queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Dialect generates the SQL statements and runs the introspection queries
+// of a database engine. Schemas returned by Inspect must be normalized to the
+// vocabulary of the type registry and FormatDefault, with referential actions
+// normalized like the ones declared by the models, so they can be compared
+// with the schema declared by the models.
+type Dialect interface {
+	// Name returns the driver name of the dialect.
+	Name() string
+	// Types returns the registry of the SQL datatypes of the go types.
+	Types() *TypeRegistry
+	// TimeType returns the datatype of the time columns declared by the time
+	// and time_zone tags.
+	TimeType(timeType TimeType) string
+	// FormatDefault returns the SQL expression of the column default value.
+	FormatDefault(column *Column) string
+	// TransactionalDDL reports whether schema changes can be rolled back.
+	TransactionalDDL() bool
+	// CreateTable returns the statement creating the table with its columns
+	// and primary key.
+	CreateTable(table *Table) string
+	// DropTable returns the statement removing the table.
+	DropTable(table string) string
+	// RenameTable returns the statement renaming the table.
+	RenameTable(from, to string) string
+	// AddColumn returns the statement adding the column to the table.
+	AddColumn(table string, column *Column) string
+	// DropColumn returns the statement removing the column from the table.
+	DropColumn(table, column string) string
+	// RenameColumn returns the statement renaming the column of the table.
+	RenameColumn(table, from, to string) string
+	// AlterType returns the statement converting the column to its type,
+	// using the Using expression when it is set.
+	AlterType(table string, column *Column) string
+	// AddConstraint returns the statement adding the constraint to the column.
+	AddConstraint(table string, column *Column, constraint string) string
+	// DropNotNull returns the statement making the column nullable.
+	DropNotNull(table string, column *Column) string
+	// SetDefault returns the statement updating the column default value.
+	SetDefault(table string, column *Column) string
+	// DropDefault returns the statement removing the column default value.
+	DropDefault(table string, column *Column) string
+	// DropUnique returns the statement removing the unique constraint from the table.
+	DropUnique(table, name string) string
+	// AddCheck returns the statement adding the check constraint to the table.
+	AddCheck(table string, check *Check) string
+	// DropCheck returns the statement removing the check constraint from the table.
+	DropCheck(table, name string) string
+	// CreateIndex returns the statement creating the index on the table.
+	CreateIndex(table string, index *IndexDef) string
+	// RenameIndex returns the statement renaming the index of the table.
+	RenameIndex(table, from, to string) string
+	// DropIndex returns the statement removing the index from the table.
+	DropIndex(table, index string) string
+	// CreateHistoryTable returns the statement creating the migration history table.
+	CreateHistoryTable(table string) string
+	// Placeholder returns the bind variable of the nth (starting at 1) query argument.
+	Placeholder(n int) string
+	// QuoteIdentifier returns the quoted table or column name.
+	QuoteIdentifier(name string) string
+	// AddForeignKey returns the statement adding the foreign key to the table.
+	AddForeignKey(table string, foreignKey *ForeignKey) string
+	// DropForeignKey returns the statement removing the foreign key from the table.
+	DropForeignKey(table, name string) string
+	// TableExists reports whether the table exists.
+	TableExists(ctx context.Context, q Queryer, table string) (bool, error)
+	// Inspect reads the tables of the current schema.
+	Inspect(ctx context.Context, q Queryer) (*Schema, error)
+}
+
+// VersionDetector is implemented by the dialects whose statements depend on
+// the version of the database server.
+type VersionDetector interface {
+	// DetectVersion returns the dialect for the server version.
+	DetectVersion(ctx context.Context, q Queryer) (Dialect, error)
+}
+
+// createTable builds a CREATE TABLE statement from the column definitions and
+// the table constraints, the primary key is a table constraint so it can have
+// several columns.
+func createTable(table *Table, definition func(column *Column) string, constraints ...string) string {
+	var lines []string
+	for _, column := range table.Columns {
+		lines = append(lines, column.Name+" "+definition(column))
+	}
+	if len(table.PrimaryKey) > 0 {
+		lines = append(lines, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(table.PrimaryKey, ", ")))
+	}
+	for _, column := range table.Columns {
+		if !column.Unique || (len(table.PrimaryKey) == 1 && column.PrimaryKey) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(
+			"CONSTRAINT %s UNIQUE (%s)",
+			uniqueConstraintName(table.Name, column.Name),
+			column.Name,
+		))
+	}
+	for _, check := range table.Checks {
+		lines = append(lines, fmt.Sprintf("CONSTRAINT %s CHECK (%s)", check.Name, check.Expression))
+	}
+	lines = append(lines, constraints...)
+
+	return fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s\n(\n\t\t%s\n);",
+		table.Name,
+		strings.Join(lines, ",\n\t\t"),
+	)
+}
+
+func uniqueConstraintName(table, column string) string {
+	return fmt.Sprintf("unique_%s_%s", table, column)
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = make(map[string]Dialect)
+)
+
+// RegisterDialect makes a dialect available by the provided driver name. If
+// RegisterDialect is called twice with the same name or if dialect is nil,
+// it panics.
+func RegisterDialect(name string, dialect Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	if dialect == nil {
+		panic("migration: RegisterDialect dialect is nil")
+	}
+	if _, dup := dialects[name]; dup {
+		panic("migration: RegisterDialect called twice for dialect " + name)
+	}
+	dialects[name] = dialect
+}
+
+// Dialects returns a sorted list of the names of the registered dialects.
+func Dialects() []string {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+	list := make([]string, 0, len(dialects))
+	for name := range dialects {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+func lookupDialect(name string) (Dialect, error) {
+	dialectsMu.RLock()
+	dialect, ok := dialects[name]
+	dialectsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown driver: %v, allowed drivers: %v", name, Dialects())
+	}
+
+	return dialect, nil
+}
@@ -0,0 +1,337 @@
+package migration
+
+import (
+	"sort"
+	"strings"
+)
+
+// ChangeKind is the kind of difference between two schemas.
+type ChangeKind string
+
+const (
+	ChangeAddTable        ChangeKind = "add table"
+	ChangeRenameTable     ChangeKind = "rename table"
+	ChangeDropTable       ChangeKind = "drop table"
+	ChangeAddColumn       ChangeKind = "add column"
+	ChangeDropColumn      ChangeKind = "drop column"
+	ChangeRenameColumn    ChangeKind = "rename column"
+	ChangeAlterType       ChangeKind = "alter type"
+	ChangeSetNotNull      ChangeKind = "set not null"
+	ChangeDropNotNull     ChangeKind = "drop not null"
+	ChangeAddUnique       ChangeKind = "add unique"
+	ChangeDropUnique      ChangeKind = "drop unique"
+	ChangeSetDefault      ChangeKind = "set default"
+	ChangeDropDefault     ChangeKind = "drop default"
+	ChangeAddIndex        ChangeKind = "add index"
+	ChangeAlterIndex      ChangeKind = "alter index"
+	ChangeRenameIndex     ChangeKind = "rename index"
+	ChangeDropIndex       ChangeKind = "drop index"
+	ChangeAddForeignKey   ChangeKind = "add foreign key"
+	ChangeAlterForeignKey ChangeKind = "alter foreign key"
+	ChangeDropForeignKey  ChangeKind = "drop foreign key"
+	ChangeAddCheck        ChangeKind = "add check"
+	ChangeDropCheck       ChangeKind = "drop check"
+	// ChangeRebuildTable replaces the Rebuilt changes of a table for the
+	// dialects implementing TableRebuilder, it is not returned by Diff.
+	ChangeRebuildTable ChangeKind = "rebuild table"
+)
+
+// Change is a difference between the desired and the current schema. Table
+// is the desired table, or the current one when it is dropped. The other
+// fields are set depending on the kind: the desired Column, Index,
+// ForeignKey and Check, and their Current definition when it exists. The
+// CurrentIndex of a dropped unique constraint is its index.
+type Change struct {
+	Kind              ChangeKind
+	Table             *Table
+	CurrentTable      *Table
+	Column            *Column
+	CurrentColumn     *Column
+	Index             *IndexDef
+	CurrentIndex      *IndexDef
+	ForeignKey        *ForeignKey
+	CurrentForeignKey *ForeignKey
+	Check             *Check
+	CurrentCheck      *Check
+	Rebuilt           []Change
+}
+
+// Diff returns the changes migrating the current schema to the desired one,
+// in the order of the desired tables. Tables of the current schema missing
+// from the desired one are reported last, the columns missing from a desired
+// table after its other column changes. Tables and columns are renamed when
+// their RenamedFrom name exists and their name does not. Only the indexes,
+// unique constraints, foreign keys and checks named by the migrator are
+// dropped, indexes named 'index_<column>' by previous versions are renamed.
+// Checks are compared by name, databases rewrite their expression.
+func Diff(desired, current *Schema) []Change {
+	// renamed maps the current names of the renamed tables to their desired name
+	renamed := make(map[string]string)
+	for _, table := range desired.Tables {
+		if table.RenamedFrom != "" && current.Table(table.Name) == nil &&
+			current.Table(table.RenamedFrom) != nil && desired.Table(table.RenamedFrom) == nil {
+			renamed[table.RenamedFrom] = table.Name
+		}
+	}
+	var changes []Change
+	for _, table := range desired.Tables {
+		existing := current.Table(table.Name)
+		if existing == nil && renamed[table.RenamedFrom] == table.Name {
+			existing = current.Table(table.RenamedFrom)
+			changes = append(changes, Change{Kind: ChangeRenameTable, Table: table, CurrentTable: existing})
+		}
+		columns := make(map[string]string)
+		if existing == nil {
+			changes = append(changes, Change{Kind: ChangeAddTable, Table: table})
+			existing = &Table{Name: table.Name}
+		} else {
+			var columnChanges []Change
+			columnChanges, columns = diffColumns(table, existing)
+			changes = append(changes, columnChanges...)
+		}
+		changes = append(changes, diffIndexes(table, existing, columns)...)
+		changes = append(changes, diffForeignKeys(table, existing, columns, renamed)...)
+		changes = append(changes, diffChecks(table, existing)...)
+	}
+	for _, table := range current.Tables {
+		if desired.Table(table.Name) == nil && renamed[table.Name] == "" {
+			changes = append(changes, Change{Kind: ChangeDropTable, Table: table})
+		}
+	}
+
+	return changes
+}
+
+// diffColumns returns the column changes of the table and the current names
+// of the renamed columns mapped to their desired name
+func diffColumns(table, existing *Table) ([]Change, map[string]string) {
+	var changes []Change
+	renamed := make(map[string]string)
+	for _, column := range table.Columns {
+		current := existing.Column(column.Name)
+		// currentName is the name of the column in the current schema
+		currentName := column.Name
+		if current == nil && column.RenamedFrom != "" && table.Column(column.RenamedFrom) == nil {
+			if previous := existing.Column(column.RenamedFrom); previous != nil {
+				currentName = previous.Name
+				changes = append(changes, Change{Kind: ChangeRenameColumn, Table: table, Column: column, CurrentColumn: previous})
+				renamed[previous.Name] = column.Name
+				// the next changes apply to the renamed column
+				current = &Column{}
+				*current = *previous
+				current.Name = column.Name
+			}
+		}
+		if current == nil {
+			// added columns are declared with their NOT NULL constraint and default
+			changes = append(changes, Change{Kind: ChangeAddColumn, Table: table, Column: column})
+			if column.Unique {
+				changes = append(changes, Change{Kind: ChangeAddUnique, Table: table, Column: column})
+			}
+			continue
+		}
+		if !strings.EqualFold(current.Type, column.Type) {
+			changes = append(changes, Change{Kind: ChangeAlterType, Table: table, Column: column, CurrentColumn: current})
+		}
+		if column.NotNull && !current.NotNull {
+			changes = append(changes, Change{Kind: ChangeSetNotNull, Table: table, Column: column, CurrentColumn: current})
+		} else if !column.NotNull && !column.PrimaryKey && current.NotNull {
+			changes = append(changes, Change{Kind: ChangeDropNotNull, Table: table, Column: column, CurrentColumn: current})
+		}
+		if column.Unique && !current.Unique {
+			changes = append(changes, Change{Kind: ChangeAddUnique, Table: table, Column: column, CurrentColumn: current})
+		} else if !column.Unique && !column.PrimaryKey && current.Unique {
+			if index := uniqueConstraint(existing, currentName); index != nil && !hasUniqueIndex(table, column.Name) {
+				changes = append(changes, Change{Kind: ChangeDropUnique, Table: table, Column: column, CurrentColumn: current, CurrentIndex: index})
+			}
+		}
+		if column.HasDefault && (!current.HasDefault || !strings.EqualFold(current.Default, column.Default)) {
+			changes = append(changes, Change{Kind: ChangeSetDefault, Table: table, Column: column, CurrentColumn: current})
+		} else if !column.HasDefault && current.HasDefault && !column.AutoIncrement && !current.AutoIncrement {
+			changes = append(changes, Change{Kind: ChangeDropDefault, Table: table, Column: column, CurrentColumn: current})
+		}
+	}
+	for _, current := range existing.Columns {
+		if table.Column(current.Name) == nil && renamed[current.Name] == "" {
+			changes = append(changes, Change{Kind: ChangeDropColumn, Table: table, CurrentColumn: current})
+		}
+	}
+
+	return changes, renamed
+}
+
+// uniqueConstraint returns the index of the unique constraint created by the
+// migrator for the column of the table, nil if it does not exist
+func uniqueConstraint(table *Table, column string) *IndexDef {
+	name := uniqueConstraintName(table.Name, column)
+	for _, index := range table.Indexes {
+		if index.Name == name {
+			return index
+		}
+	}
+
+	return nil
+}
+
+// hasUniqueIndex reports whether a unique index of the table is made of the
+// column, it makes the column unique
+func hasUniqueIndex(table *Table, column string) bool {
+	for _, index := range table.Indexes {
+		if index.Unique && len(index.Columns) == 1 && index.Columns[0] == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renameColumns returns the columns with their desired name
+func renameColumns(columns []string, renamed map[string]string) []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column
+		if name, ok := renamed[column]; ok {
+			names[i] = name
+		}
+	}
+
+	return names
+}
+
+func sameIndex(a, b *IndexDef) bool {
+	return a.Unique == b.Unique && strings.Join(a.Columns, ",") == strings.Join(b.Columns, ",")
+}
+
+// previousIndexName returns the name of a managed index of the table before
+// the table was renamed
+func previousIndexName(name, table, previous string) string {
+	for _, prefix := range []string{"idx_", "uidx_"} {
+		if strings.HasPrefix(name, prefix+table+"_") {
+			return prefix + previous + "_" + strings.TrimPrefix(name, prefix+table+"_")
+		}
+	}
+
+	return name
+}
+
+func diffIndexes(table, existing *Table, columns map[string]string) []Change {
+	var changes []Change
+	indexes := make(map[string]*IndexDef)
+	for _, index := range existing.Indexes {
+		renamed := *index
+		renamed.Columns = renameColumns(index.Columns, columns)
+		indexes[index.Name] = &renamed
+	}
+	matched := make(map[string]bool)
+	for _, index := range table.Indexes {
+		current, ok := indexes[index.Name]
+		if ok {
+			matched[index.Name] = true
+			if !sameIndex(current, index) {
+				changes = append(changes, Change{Kind: ChangeAlterIndex, Table: table, Index: index, CurrentIndex: current})
+			}
+			continue
+		}
+		previous, isPrevious := indexes[previousIndexName(index.Name, table.Name, existing.Name)]
+		if !isPrevious && len(index.Columns) == 1 {
+			previous, isPrevious = indexes["index_"+index.Columns[0]]
+		}
+		if isPrevious && sameIndex(previous, index) {
+			matched[previous.Name] = true
+			changes = append(changes, Change{Kind: ChangeRenameIndex, Table: table, Index: index, CurrentIndex: previous})
+			continue
+		}
+		changes = append(changes, Change{Kind: ChangeAddIndex, Table: table, Index: index})
+	}
+	var names []string
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if matched[name] || !isManagedIndex(table.Name, name) && !isManagedIndex(existing.Name, name) {
+			continue
+		}
+		changes = append(changes, Change{Kind: ChangeDropIndex, Table: table, CurrentIndex: indexes[name]})
+	}
+
+	return changes
+}
+
+func diffForeignKeys(table, existing *Table, columns, tables map[string]string) []Change {
+	var changes []Change
+	foreignKeys := make(map[string]*ForeignKey)
+	previousNames := make(map[string]string)
+	for _, foreignKey := range existing.ForeignKeys {
+		renamed := *foreignKey
+		renamed.Column = renameColumns([]string{foreignKey.Column}, columns)[0]
+		if name, ok := tables[foreignKey.RefTable]; ok {
+			renamed.RefTable = name
+		}
+		foreignKeys[foreignKey.Name] = &renamed
+		// foreign keys named after the previous table or column name
+		previousNames[foreignKeyName(table.Name, renamed.Column)] = foreignKey.Name
+	}
+	matched := make(map[string]bool)
+	for _, foreignKey := range table.ForeignKeys {
+		current, ok := foreignKeys[foreignKey.Name]
+		if !ok {
+			current, ok = foreignKeys[previousNames[foreignKey.Name]]
+		}
+		if !ok {
+			changes = append(changes, Change{Kind: ChangeAddForeignKey, Table: table, ForeignKey: foreignKey})
+			continue
+		}
+		matched[current.Name] = true
+		if *current != *foreignKey {
+			changes = append(changes, Change{Kind: ChangeAlterForeignKey, Table: table, ForeignKey: foreignKey, CurrentForeignKey: current})
+		}
+	}
+	for _, foreignKey := range existing.ForeignKeys {
+		if matched[foreignKey.Name] || !isManagedConstraint("fk_", table.Name, foreignKey.Name) && !isManagedConstraint("fk_", existing.Name, foreignKey.Name) {
+			continue
+		}
+		changes = append(changes, Change{Kind: ChangeDropForeignKey, Table: table, CurrentForeignKey: foreignKeys[foreignKey.Name]})
+	}
+
+	return changes
+}
+
+func diffChecks(table, existing *Table) []Change {
+	var changes []Change
+	checks := make(map[string]*Check)
+	for _, check := range existing.Checks {
+		checks[check.Name] = check
+	}
+	for _, check := range table.Checks {
+		if checks[check.Name] == nil {
+			changes = append(changes, Change{Kind: ChangeAddCheck, Table: table, Check: check})
+		}
+	}
+	for _, current := range existing.Checks {
+		if !isManagedConstraint("chk_", table.Name, current.Name) && !isManagedConstraint("chk_", existing.Name, current.Name) {
+			continue
+		}
+		if !hasCheck(table, current.Name) {
+			changes = append(changes, Change{Kind: ChangeDropCheck, Table: table, CurrentCheck: current})
+		}
+	}
+
+	return changes
+}
+
+func hasCheck(table *Table, name string) bool {
+	for _, check := range table.Checks {
+		if check.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isManagedConstraint reports whether the constraint is named by the migrator
+// for the table, like fk_<table>_<column>
+func isManagedConstraint(prefix, table, name string) bool {
+	return strings.HasPrefix(name, prefix+table+"_")
+}
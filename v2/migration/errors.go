@@ -0,0 +1,164 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	ErrLossyTypeChange = errors.New("lossy type change")
+	// ErrInvalidConstraint reports the constraints, references, referential
+	// actions and index options of the tags which are not valid.
+	ErrInvalidConstraint = errors.New("invalid constraint")
+	// ErrUnsupportedType reports the fields whose go type has no SQL datatype
+	// in the dialect, set their type tag.
+	ErrUnsupportedType = errors.New("unsupported type")
+	// ErrDuplicateColumn matches the driver errors of columns added twice.
+	ErrDuplicateColumn = errors.New("duplicate column")
+	// ErrInvalidTag reports the migration tags which cannot be parsed.
+	ErrInvalidTag = errors.New("invalid tag")
+	// ErrLegacyTimeColumn reports the TIMETZ columns created for time.Time
+	// fields by previous versions, which are kept unless lossy type changes
+	// are allowed.
+	ErrLegacyTimeColumn = errors.New("legacy time column")
+	// ErrNotNullWithoutDefault reports the NOT NULL columns without default
+	// added to tables which have rows.
+	ErrNotNullWithoutDefault = errors.New("not null column without default")
+	// ErrDriverMismatch reports the plans applied by a migrator of another
+	// driver than the one they were built for.
+	ErrDriverMismatch = errors.New("driver mismatch")
+)
+
+// TagError is returned for a field of a model whose migration tag cannot be
+// parsed.
+type TagError struct {
+	Struct string
+	Field  string
+	Tag    string
+	Err    error
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("%s.%s: tag %q: %v", e.Struct, e.Field, e.Tag, e.Err)
+}
+
+func (e *TagError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationError describes the table and column of a model which cannot be
+// migrated, Statement is set when the error Err was returned by the database
+// driver executing it.
+type MigrationError struct {
+	Table     string
+	Column    string
+	Statement string
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	target := e.Table
+	if e.Column != "" {
+		target += "." + e.Column
+	}
+	if e.Statement != "" {
+		return fmt.Sprintf("%s: %s: %v", target, e.Statement, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %v", target, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the errors of the package with the error codes of the database
+// drivers.
+func (e *MigrationError) Is(target error) bool {
+	return target == ErrDuplicateColumn && isDuplicateColumn(e.Err)
+}
+
+// isDuplicateColumn reports whether the driver error is raised by a column
+// added twice: 1060 for MySQL and MariaDB, 42701 for Postgres and CockroachDB
+// and 2705 for SQL Server.
+func isDuplicateColumn(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1060
+	}
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState() == "42701"
+	}
+	var mssqlErr interface{ SQLErrorNumber() int32 }
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.SQLErrorNumber() == 2705
+	}
+
+	return false
+}
+
+// isUnknownTable reports whether the driver error is raised by a table of
+// information_schema missing from the MySQL server version: 1109.
+func isUnknownTable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1109
+}
+
+// DestructiveChangeError is returned when the destructive policy denies the
+// changes of a migration.
+type DestructiveChangeError struct {
+	Changes []Statement
+}
+
+func (e *DestructiveChangeError) Error() string {
+	changes := make([]string, len(e.Changes))
+	for i, change := range e.Changes {
+		target := change.Table
+		if change.Column != "" {
+			target += "." + change.Column
+		}
+		changes[i] = fmt.Sprintf("%s (%s)", target, change.Destructive)
+	}
+
+	return fmt.Sprintf("destructive changes denied: %s", strings.Join(changes, ", "))
+}
+
+// PartialMigrationError is returned by dialects without transactional DDL
+// when a migration fails after some statements were executed. It is the
+// compensation log used to recover the database.
+type PartialMigrationError struct {
+	Executed []Statement
+	Failed   Statement
+	Err      error
+}
+
+func (e *PartialMigrationError) Error() string {
+	return fmt.Sprintf(
+		"migration failed after %d executed statements: %v",
+		len(e.Executed),
+		e.Err,
+	)
+}
+
+func (e *PartialMigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Compensation returns the statements reverting the executed statements in
+// reverse order. Statements which cannot be reverted, like type or default
+// changes, are not part of it and must be recovered manually.
+func (e *PartialMigrationError) Compensation() []string {
+	var statements []string
+	for i := len(e.Executed) - 1; i >= 0; i-- {
+		if e.Executed[i].Undo != "" {
+			statements = append(statements, e.Executed[i].Undo)
+		}
+	}
+
+	return statements
+}
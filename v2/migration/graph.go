@@ -0,0 +1,274 @@
+package migration
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// relation is a dependency between two models declared by a field typed after
+// a model: the table depends on the referenced table.
+type relation struct {
+	Field      string
+	Table      *Table
+	Referenced *Table
+}
+
+// relationType returns the struct type of a model field, dereferencing
+// pointers and slices
+func relationType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// modelRelations returns the relations declared by the fields of the models:
+// a struct or pointer field makes the model depend on the field model, a
+// slice field makes the field model depend on the model.
+func modelRelations(models []reflect.Type, tables map[reflect.Type]*Table) []relation {
+	var relations []relation
+	for _, model := range models {
+		for i := 0; i < model.NumField(); i++ {
+			field := model.Field(i)
+			referenced, ok := tables[relationType(field.Type)]
+			if !ok || referenced == tables[model] {
+				continue
+			}
+			if field.Type.Kind() == reflect.Slice {
+				relations = append(relations, relation{Table: referenced, Referenced: tables[model]})
+				continue
+			}
+			relations = append(relations, relation{Field: field.Name, Table: tables[model], Referenced: referenced})
+		}
+	}
+
+	return relations
+}
+
+// inferForeignKeys adds foreign keys to the columns named after a model of
+// the migration followed by '_id', like 'user_id' for a 'User' model, and to
+// the columns named after a relation field, like 'author_id' for an 'Author
+// *User' field
+func (m *Migrator) inferForeignKeys(tables []*Table, models []reflect.Type, relations []relation) {
+	referenced := make(map[*Table]map[string]*Table)
+	for _, table := range tables {
+		referenced[table] = make(map[string]*Table)
+		for i, model := range models {
+			if tables[i] != table {
+				referenced[table][toSnakeCase(model.Name())+"_id"] = tables[i]
+			}
+		}
+	}
+	for _, r := range relations {
+		if r.Field != "" {
+			referenced[r.Table][toSnakeCase(r.Field)+"_id"] = r.Referenced
+		}
+	}
+	for _, table := range tables {
+		declared := make(map[string]bool)
+		for _, foreignKey := range table.ForeignKeys {
+			declared[foreignKey.Column] = true
+		}
+		for _, column := range table.Columns {
+			target, ok := referenced[table][column.Name]
+			// composite primary keys cannot be referenced by a column
+			if !ok || declared[column.Name] || len(target.PrimaryKey) != 1 {
+				continue
+			}
+			table.ForeignKeys = append(table.ForeignKeys, &ForeignKey{
+				Name:      foreignKeyName(table.Name, column.Name),
+				Column:    column.Name,
+				RefTable:  target.Name,
+				RefColumn: target.PrimaryKey[0],
+				OnDelete:  "NO ACTION",
+				OnUpdate:  "NO ACTION",
+			})
+		}
+	}
+}
+
+// orderTables sorts the tables so the referenced tables are migrated first,
+// tables of a cycle keep the order of the arguments. It returns the foreign
+// keys between tables of a cycle, they must be created once every table of
+// the cycle exists, and an error if rows could never be inserted in a cycle
+// because all its foreign keys are NOT NULL.
+func orderTables(tables []*Table, relations []relation) ([]*Table, map[*ForeignKey]bool, error) {
+	index := make(map[string]int)
+	for i, table := range tables {
+		index[table.Name] = i
+	}
+	edges := make([][]int, len(tables))
+	for i, table := range tables {
+		for _, foreignKey := range table.ForeignKeys {
+			if j, ok := index[foreignKey.RefTable]; ok {
+				edges[i] = append(edges[i], j)
+			}
+		}
+	}
+	for _, r := range relations {
+		edges[index[r.Table.Name]] = append(edges[index[r.Table.Name]], index[r.Referenced.Name])
+	}
+	components := stronglyConnectedComponents(edges)
+	component := make([]int, len(tables))
+	for c, nodes := range components {
+		for _, node := range nodes {
+			component[node] = c
+		}
+	}
+	cyclic := make(map[*ForeignKey]bool)
+	for i, table := range tables {
+		for _, foreignKey := range table.ForeignKeys {
+			if j, ok := index[foreignKey.RefTable]; ok && component[i] == component[j] {
+				cyclic[foreignKey] = true
+			}
+		}
+	}
+	ordered := make([]*Table, 0, len(tables))
+	for _, nodes := range components {
+		if len(nodes) > 1 {
+			err := checkCycle(tables, nodes, index)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		for _, node := range nodes {
+			ordered = append(ordered, tables[node])
+		}
+	}
+
+	return ordered, cyclic, nil
+}
+
+// stronglyConnectedComponents returns the strongly connected components of
+// the graph with Tarjan's algorithm. A component is returned after the
+// components it has edges to, the nodes of a component are sorted.
+func stronglyConnectedComponents(edges [][]int) [][]int {
+	index := make([]int, len(edges))
+	lowLink := make([]int, len(edges))
+	onStack := make([]bool, len(edges))
+	var stack []int
+	var components [][]int
+	next := 1
+	var connect func(node int)
+	connect = func(node int) {
+		index[node] = next
+		lowLink[node] = next
+		next++
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, target := range edges[node] {
+			if index[target] == 0 {
+				connect(target)
+				if lowLink[target] < lowLink[node] {
+					lowLink[node] = lowLink[target]
+				}
+			} else if onStack[target] && index[target] < lowLink[node] {
+				lowLink[node] = index[target]
+			}
+		}
+		if lowLink[node] != index[node] {
+			return
+		}
+		var nodes []int
+		for {
+			last := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[last] = false
+			nodes = append(nodes, last)
+			if last == node {
+				break
+			}
+		}
+		sort.Ints(nodes)
+		components = append(components, nodes)
+	}
+	for node := range edges {
+		if index[node] == 0 {
+			connect(node)
+		}
+	}
+
+	return components
+}
+
+// checkCycle returns an error if the tables of the component are linked by a
+// cycle of NOT NULL foreign keys, no row could be inserted in such tables
+func checkCycle(tables []*Table, nodes []int, index map[string]int) error {
+	inComponent := make(map[int]bool)
+	for _, node := range nodes {
+		inComponent[node] = true
+	}
+	edges := make([][]int, len(tables))
+	for _, node := range nodes {
+		for _, foreignKey := range tables[node].ForeignKeys {
+			j, ok := index[foreignKey.RefTable]
+			if !ok || j == node || !inComponent[j] || !isNotNullColumn(tables[node], foreignKey.Column) {
+				continue
+			}
+			edges[node] = append(edges[node], j)
+		}
+	}
+	for _, component := range stronglyConnectedComponents(edges) {
+		if len(component) < 2 {
+			continue
+		}
+		inCycle := make(map[int]bool)
+		for _, node := range component {
+			inCycle[node] = true
+		}
+		var names []string
+		for _, node := range component {
+			for _, foreignKey := range tables[node].ForeignKeys {
+				j, ok := index[foreignKey.RefTable]
+				if ok && j != node && inCycle[j] && isNotNullColumn(tables[node], foreignKey.Column) {
+					names = append(names, fmt.Sprintf("%s.%s -> %s", tables[node].Name, foreignKey.Column, foreignKey.RefTable))
+				}
+			}
+		}
+		return fmt.Errorf(
+			"unresolvable foreign key cycle: %s, at least one of the columns must be nullable",
+			strings.Join(names, ", "),
+		)
+	}
+
+	return nil
+}
+
+func isNotNullColumn(table *Table, name string) bool {
+	for _, column := range table.Columns {
+		if column.Name == name {
+			return column.NotNull || column.PrimaryKey
+		}
+	}
+
+	return false
+}
+
+// dropOrder sorts the tables so the tables referencing another table are
+// dropped first
+func dropOrder(tables []*Table) []*Table {
+	index := make(map[string]int)
+	for i, table := range tables {
+		index[table.Name] = i
+	}
+	edges := make([][]int, len(tables))
+	for i, table := range tables {
+		for _, foreignKey := range table.ForeignKeys {
+			if j, ok := index[foreignKey.RefTable]; ok {
+				edges[i] = append(edges[i], j)
+			}
+		}
+	}
+	components := stronglyConnectedComponents(edges)
+	ordered := make([]*Table, 0, len(tables))
+	for c := len(components) - 1; c >= 0; c-- {
+		for _, node := range components[c] {
+			ordered = append(ordered, tables[node])
+		}
+	}
+
+	return ordered
+}
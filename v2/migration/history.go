@@ -0,0 +1,171 @@
+package migration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const historyVersionLayout = "20060102150405.000000"
+
+// HistoryEntry is a model revision recorded in the migration history table.
+type HistoryEntry struct {
+	Version    string
+	Table      string
+	Checksum   string
+	AppliedAt  time.Time
+	Duration   time.Duration
+	Statements []string
+}
+
+// ModelRevision identifies the definition of a model by the checksum of its
+// columns.
+type ModelRevision struct {
+	Table    string
+	Checksum string
+}
+
+// modelChecksum returns the checksum of the table definition generated for
+// the dialect, so option or type mapping updates are detected as well.
+func modelChecksum(dialect Dialect, table *Table) string {
+	definition, _ := json.Marshal(struct {
+		Driver string
+		Table  *Table
+	}{
+		Driver: dialect.Name(),
+		Table:  table,
+	})
+	sum := sha256.Sum256(definition)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// History returns the entries of the migration history table ordered by
+// version.
+func (m *Migrator) History() ([]HistoryEntry, error) {
+	return m.HistoryContext(context.Background())
+}
+
+// HistoryContext is History with a context.
+func (m *Migrator) HistoryContext(ctx context.Context) ([]HistoryEntry, error) {
+	dialect, err := m.dialectContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.readHistory(ctx, dialect)
+}
+
+func (m *Migrator) readHistory(ctx context.Context, dialect Dialect) ([]HistoryEntry, error) {
+	if m.HistoryTable == "" {
+		return nil, nil
+	}
+	exists, err := dialect.TableExists(ctx, m.DB, m.HistoryTable)
+	if err != nil || !exists {
+		return nil, err
+	}
+	query := fmt.Sprintf(
+		`SELECT version, table_name, checksum, applied_at, duration_ms, statements
+				FROM %s ORDER BY version, table_name;`,
+		m.HistoryTable,
+	)
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var appliedAt interface{}
+		var duration int64
+		var statements string
+		err = rows.Scan(&entry.Version, &entry.Table, &entry.Checksum, &appliedAt, &duration, &statements)
+		if err != nil {
+			return nil, err
+		}
+		entry.AppliedAt, err = parseHistoryTime(appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.Duration = time.Duration(duration) * time.Millisecond
+		err = json.Unmarshal([]byte(statements), &entry.Statements)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// parseHistoryTime reads applied_at from drivers which do not parse times
+func parseHistoryTime(value interface{}) (time.Time, error) {
+	var s string
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return time.Time{}, fmt.Errorf("unsupported applied_at value: %v", value)
+	}
+	t, err := time.Parse("2006-01-02 15:04:05.999999", s)
+	if err != nil {
+		return time.Parse(time.RFC3339Nano, s)
+	}
+
+	return t, nil
+}
+
+// lastChecksums returns the checksum of the last revision applied to each
+// table.
+func (m *Migrator) lastChecksums(ctx context.Context, dialect Dialect) (map[string]string, error) {
+	entries, err := m.readHistory(ctx, dialect)
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string)
+	for _, entry := range entries {
+		checksums[entry.Table] = entry.Checksum
+	}
+
+	return checksums, nil
+}
+
+func (m *Migrator) recordHistory(ctx context.Context, q Queryer, dialect Dialect, version string, revision ModelRevision, duration time.Duration, statements []string) error {
+	if statements == nil {
+		statements = []string{}
+	}
+	encoded, err := json.Marshal(statements)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (version, table_name, checksum, applied_at, duration_ms, statements) VALUES (%s, %s, %s, %s, %s, %s);",
+		m.HistoryTable,
+		dialect.Placeholder(1),
+		dialect.Placeholder(2),
+		dialect.Placeholder(3),
+		dialect.Placeholder(4),
+		dialect.Placeholder(5),
+		dialect.Placeholder(6),
+	)
+	_, err = q.ExecContext(
+		ctx,
+		query,
+		version,
+		revision.Table,
+		revision.Checksum,
+		time.Now().UTC(),
+		duration.Milliseconds(),
+		string(encoded),
+	)
+
+	return err
+}
@@ -0,0 +1,122 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Hooks receives the events of the migrations. BeforeStatement and
+// AfterStatement are called around every statement executed by Apply,
+// OnModelStart and OnModelDone around the statements of each table, and
+// OnWarning for the invalid values of the models and the destructive changes
+// applied by the DestructiveWarn policy. Embed NopHooks to implement only some
+// of them.
+type Hooks interface {
+	BeforeStatement(ctx context.Context, statement Statement)
+	AfterStatement(ctx context.Context, statement Statement, duration time.Duration, err error)
+	OnModelStart(ctx context.Context, table string)
+	OnModelDone(ctx context.Context, table string, duration time.Duration, err error)
+	OnWarning(ctx context.Context, err error)
+}
+
+// NopHooks implements Hooks without doing anything.
+type NopHooks struct{}
+
+func (NopHooks) BeforeStatement(context.Context, Statement) {}
+
+func (NopHooks) AfterStatement(context.Context, Statement, time.Duration, error) {}
+
+func (NopHooks) OnModelStart(context.Context, string) {}
+
+func (NopHooks) OnModelDone(context.Context, string, time.Duration, error) {}
+
+func (NopHooks) OnWarning(context.Context, error) {}
+
+func (m *Migrator) logger() *slog.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+
+	return slog.Default()
+}
+
+func (m *Migrator) beforeStatement(ctx context.Context, statement Statement) {
+	m.logger().DebugContext(
+		ctx,
+		"executing statement",
+		"table", statement.Table,
+		"column", statement.Column,
+		"reason", statement.Reason,
+		"sql", statement.SQL,
+	)
+	if m.Hooks != nil {
+		m.Hooks.BeforeStatement(ctx, statement)
+	}
+}
+
+func (m *Migrator) afterStatement(ctx context.Context, statement Statement, duration time.Duration, err error) {
+	level := slog.LevelDebug
+	message := "statement executed"
+	if err != nil {
+		level = slog.LevelError
+		message = "statement failed"
+	}
+	m.logger().Log(
+		ctx,
+		level,
+		message,
+		"table", statement.Table,
+		"column", statement.Column,
+		"reason", statement.Reason,
+		"sql", statement.SQL,
+		"duration", duration,
+		"error", err,
+	)
+	if m.Hooks != nil {
+		m.Hooks.AfterStatement(ctx, statement, duration, err)
+	}
+}
+
+func (m *Migrator) modelStart(ctx context.Context, table string) {
+	m.logger().DebugContext(ctx, "migrating model", "table", table)
+	if m.Hooks != nil {
+		m.Hooks.OnModelStart(ctx, table)
+	}
+}
+
+func (m *Migrator) modelDone(ctx context.Context, table string, duration time.Duration, err error) {
+	if err != nil {
+		m.logger().ErrorContext(ctx, "model migration failed", "table", table, "duration", duration, "error", err)
+	} else {
+		m.logger().DebugContext(ctx, "model migrated", "table", table, "duration", duration)
+	}
+	if m.Hooks != nil {
+		m.Hooks.OnModelDone(ctx, table, duration, err)
+	}
+}
+
+// warn reports an invalid value of the models, it is returned in strict mode
+// and logged otherwise.
+func (m *Migrator) warn(ctx context.Context, err error) error {
+	if m.Strict {
+		return err
+	}
+	m.warning(ctx, "invalid value ignored", err)
+
+	return nil
+}
+
+// warning logs the warning and calls the OnWarning hook
+func (m *Migrator) warning(ctx context.Context, message string, err error) {
+	attributes := []any{"error", err}
+	var migrationErr *MigrationError
+	if errors.As(err, &migrationErr) {
+		attributes = append(attributes, "table", migrationErr.Table, "column", migrationErr.Column)
+	}
+	m.logger().WarnContext(ctx, message, attributes...)
+	if m.Hooks != nil {
+		m.Hooks.OnWarning(ctx, err)
+	}
+}
@@ -0,0 +1,134 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxIdentifierLength is the shortest identifier limit of the supported
+// databases (63 characters for Postgres)
+const maxIdentifierLength = 63
+
+// IndexDef describes an index. Models can declare indexes with the index and
+// unique_index tags or by implementing the Indexes method:
+//
+//	func (User) Indexes() []migration.IndexDef {
+//		return []migration.IndexDef{{Name: "name", Columns: []string{"last_name", "first_name"}}}
+//	}
+//
+// Declared names are qualified by the table name in the database, Columns are
+// the column names.
+type IndexDef struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// indexDefiner is implemented by the models declaring indexes.
+type indexDefiner interface {
+	Indexes() []IndexDef
+}
+
+// indexKey identifies an index declared by the field tags
+type indexKey struct {
+	name   string
+	unique bool
+}
+
+// indexPart is a column of an index declared by a field tag
+type indexPart struct {
+	column string
+	order  int
+}
+
+// parseIndexTag returns the name and the position of the column in the index
+// declared by an 'index' or 'unique_index' tag value, like 'name,order:2'.
+// Orders which are not valid are ignored and reported by the error.
+func parseIndexTag(column, value string) (string, int, error) {
+	name := column
+	order := 0
+	var invalid error
+	for i, option := range strings.Split(value, ",") {
+		option = strings.TrimSpace(option)
+		if strings.HasPrefix(option, "order:") {
+			n, err := strconv.Atoi(strings.TrimPrefix(option, "order:"))
+			if err != nil {
+				invalid = fmt.Errorf("%w: index %s", ErrInvalidConstraint, option)
+				continue
+			}
+			order = n
+		} else if i == 0 && option != "" {
+			name = option
+		}
+	}
+
+	return name, order, invalid
+}
+
+// qualifiedIndexName returns the database name of an index, index names are
+// global in a Postgres schema so they are prefixed by the table name
+func qualifiedIndexName(table, name string, unique bool) string {
+	prefix := "idx"
+	if unique {
+		prefix = "uidx"
+	}
+	qualified := fmt.Sprintf("%s_%s_%s", prefix, table, name)
+	if len(qualified) <= maxIdentifierLength {
+		return qualified
+	}
+	sum := sha256.Sum256([]byte(qualified))
+
+	return qualified[:maxIdentifierLength-9] + "_" + hex.EncodeToString(sum[:])[:8]
+}
+
+// isManagedIndex reports whether the index was created by the migrator for
+// the table
+func isManagedIndex(table, name string) bool {
+	return strings.HasPrefix(name, "idx_"+table+"_") || strings.HasPrefix(name, "uidx_"+table+"_")
+}
+
+// tableIndexes builds the indexes declared by the index tags of the fields and
+// the Indexes method of the model
+func tableIndexes(table string, model reflect.Type, parts map[indexKey][]indexPart) []*IndexDef {
+	var indexes []*IndexDef
+	var keys []indexKey
+	for key := range parts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return !keys[i].unique && keys[j].unique
+	})
+	for _, key := range keys {
+		columns := parts[key]
+		sort.SliceStable(columns, func(i, j int) bool {
+			return columns[i].order < columns[j].order
+		})
+		index := IndexDef{Name: qualifiedIndexName(table, key.name, key.unique), Unique: key.unique}
+		for _, part := range columns {
+			index.Columns = append(index.Columns, part.column)
+		}
+		indexes = append(indexes, &index)
+	}
+	definer, ok := reflect.New(model).Interface().(indexDefiner)
+	if !ok {
+		return indexes
+	}
+	for _, declared := range definer.Indexes() {
+		index := IndexDef{
+			Name:    qualifiedIndexName(table, declared.Name, declared.Unique),
+			Columns: append([]string(nil), declared.Columns...),
+			Unique:  declared.Unique,
+		}
+		indexes = append(indexes, &index)
+	}
+
+	return indexes
+}
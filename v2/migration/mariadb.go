@@ -0,0 +1,105 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterDialect(DBDriverMariaDB.String(), &mariadbDialect{nativeUUID: true})
+}
+
+// mariadbDialect is the MySQL dialect with the MariaDB types and defaults.
+// MariaDB has no UUID_TO_BIN function, UUIDs use the native UUID type since
+// 10.7 and a binary(16) column before.
+type mariadbDialect struct {
+	mysqlDialect
+	nativeUUID bool
+}
+
+func (d *mariadbDialect) Name() string {
+	return DBDriverMariaDB.String()
+}
+
+var mariadbVersion = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+// DetectVersion returns the dialect for the server version, the native UUID
+// type is available since MariaDB 10.7
+func (d *mariadbDialect) DetectVersion(ctx context.Context, q Queryer) (Dialect, error) {
+	var version string
+	err := q.QueryRowContext(ctx, "SELECT VERSION();").Scan(&version)
+	if err != nil {
+		return nil, err
+	}
+	match := mariadbVersion.FindStringSubmatch(version)
+	if match == nil {
+		return nil, fmt.Errorf("unknown MariaDB version: %s", version)
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+
+	return &mariadbDialect{nativeUUID: major > 10 || major == 10 && minor >= 7}, nil
+}
+
+// Types returns the MariaDB types, with binary(16) UUIDs before 10.7
+func (d *mariadbDialect) Types() *TypeRegistry {
+	if d.nativeUUID {
+		return mariadbTypes
+	}
+
+	return mariadbLegacyTypes
+}
+
+// FormatDefault returns the default value like MariaDB prints it in
+// information_schema, so it can be compared with the current default
+func (d *mariadbDialect) FormatDefault(column *Column) string {
+	value := column.Default
+	if isTextType(column.Type) {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	} else if strings.Contains(value, "uuid") {
+		if d.nativeUUID {
+			return "uuid()"
+		}
+		return "unhex(replace(uuid(),'-',''))"
+	} else if strings.EqualFold(value, "now()") {
+		return currentTimestamp(column.Type)
+	}
+
+	return d.mysqlDialect.FormatDefault(column)
+}
+
+// normalizeMariaDBDefault converts the information_schema default value to
+// the expressions returned by FormatDefault. Since 10.2.7 MariaDB quotes the
+// literals, returns expressions unquoted and 'NULL' for columns without
+// default value.
+func normalizeMariaDBDefault(column *Column, value, _ string) {
+	if value == "NULL" {
+		column.HasDefault = false
+		column.Default = ""
+		return
+	}
+	column.Default = value
+	if timestamp, ok := normalizeCurrentTimestamp(value); ok {
+		column.Default = timestamp
+	} else if !isTextType(column.Type) && len(value) > 1 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		column.Default = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	} else if !strings.HasPrefix(value, "'") {
+		column.Default = strings.ReplaceAll(value, " ", "")
+	}
+}
+
+func (d *mariadbDialect) Inspect(ctx context.Context, q Queryer) (*Schema, error) {
+	return inspectMySql(ctx, q, normalizeMariaDBDefault)
+}
+
+// DropCheck uses DROP CONSTRAINT, MariaDB has no DROP CHECK
+func (d *mariadbDialect) DropCheck(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP CONSTRAINT %s;",
+		table,
+		name,
+	)
+}
@@ -1,6 +1,7 @@
 package migration
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -16,13 +17,8 @@ func toSnakeCase(pattern string) string {
 	return strings.ToLower(snake)
 }
 
-func (m *Migrator) migrateModel(model reflect.Type) error {
-	var table string
-	if model.Kind() == reflect.Ptr {
-		table = model.Elem().Name()
-	} else {
-		table = model.Name()
-	}
+func (m *Migrator) tableName(model reflect.Type) string {
+	table := model.Name()
 	if m.TablePrefix != "" {
 		table = m.TablePrefix + table
 	}
@@ -30,60 +26,306 @@ func (m *Migrator) migrateModel(model reflect.Type) error {
 		table = toSnakeCase(table)
 	}
 
-	switch m.Driver {
-	case DBDriverMySQL:
-		err := m.createMySqlSchemas(table, model)
+	return table
+}
+
+// tableRenamer is implemented by the models whose table was renamed, the
+// RenamedFrom method returns the previous table name.
+type tableRenamer interface {
+	RenamedFrom() string
+}
+
+// parseModel builds the table declared by the model, fields typed after
+// another model of the migration are relations and not columns
+func (m *Migrator) parseModel(ctx context.Context, dialect Dialect, model reflect.Type, models map[reflect.Type]*Table) (*Table, error) {
+	table := Table{Name: m.tableName(model)}
+	indexes := make(map[indexKey][]indexPart)
+	for i := 0; i < model.NumField(); i++ {
+		field := model.Field(i)
+		if _, isRelation := models[relationType(field.Type)]; isRelation {
+			continue
+		}
+		tag, hasTag := field.Tag.Lookup("migration")
+		if tag == "-" {
+			continue
+		}
+		if _, misspelled := field.Tag.Lookup("migrations"); misspelled && !hasTag {
+			return nil, &TagError{
+				Struct: model.Name(),
+				Field:  field.Name,
+				Tag:    string(field.Tag),
+				Err:    fmt.Errorf("%w: unknown tag name migrations, did you mean migration?", ErrInvalidTag),
+			}
+		}
+		values, quoted, err := parseTag(tag)
 		if err != nil {
-			return err
+			return nil, &TagError{Struct: model.Name(), Field: field.Name, Tag: tag, Err: err}
 		}
-	case DBDriverPostgres:
-		err := m.createPostgresSchema(table, model)
+		column, err := m.parseColumn(ctx, dialect, table.Name, field, values, quoted)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	default:
-		return fmt.Errorf("unknown driver: %v, allowed drivers: [mysql,postgres]", m.Driver)
-	}
-
-	for i := 1; i < model.NumField(); i++ {
-		column := model.Field(i)
-		if strings.Compare(column.Name, "-") == 0 {
-			continue
+		table.Columns = append(table.Columns, column)
+		if column.PrimaryKey {
+			table.PrimaryKey = append(table.PrimaryKey, column.Name)
 		}
-		values := parseTag(column.Tag.Get("migration"))
-		values["column"] = toSnakeCase(column.Name)
-		_, hasType := values["type"]
-		if !hasType {
-			kind := column.Type.String()
-			values["type"] = m.convertType(kind)
+		foreignKey, err := m.parseForeignKey(ctx, table.Name, column.Name, values)
+		if err != nil {
+			return nil, err
 		}
-		switch m.Driver {
-		case DBDriverMySQL:
-			err := m.generateMySqlColumnMigration(table, values)
-			if err != nil {
-				return err
+		if foreignKey != nil {
+			table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+		}
+		for _, unique := range []bool{false, true} {
+			tag := "index"
+			if unique {
+				tag = "unique_index"
+			}
+			value, isIndex := values[tag]
+			if !isIndex {
+				continue
 			}
-		case DBDriverPostgres:
-			err := m.generatePostgresColumnMigration(table, values)
+			name, order, err := parseIndexTag(column.Name, value)
 			if err != nil {
-				return err
+				err = m.warn(ctx, &MigrationError{Table: table.Name, Column: column.Name, Err: err})
+				if err != nil {
+					return nil, err
+				}
 			}
-		default:
-			return fmt.Errorf("unknown driver: %v, allowed drivers: [mysql,postgres]", m.Driver)
+			key := indexKey{name: name, unique: unique}
+			indexes[key] = append(indexes[key], indexPart{column: column.Name, order: order})
 		}
 	}
+	table.Indexes = tableIndexes(table.Name, model, indexes)
+	if renamer, ok := reflect.New(model).Interface().(tableRenamer); ok {
+		table.RenamedFrom = renamer.RenamedFrom()
+	}
 
-	return nil
+	return &table, nil
 }
 
-func (m *Migrator) MigrateModels(models ...interface{}) error {
-	for _, model := range models {
-		reflection := reflect.TypeOf(model)
-		err := m.migrateModel(reflection)
+// planChange adds the statements applying the change to the plan. Removed
+// tables and columns are dropped when they are pruned, otherwise they are
+// reported in the orphans of the plan.
+func (m *Migrator) planChange(ctx context.Context, dialect Dialect, plan *MigrationPlan, change Change) error {
+	table := change.Table.Name
+	column, current := change.Column, change.CurrentColumn
+	switch change.Kind {
+	case ChangeAddTable:
+		plan.add(table, "", ReasonNewTable, dialect.CreateTable(change.Table), dialect.DropTable(table))
+	case ChangeRenameTable:
+		from := change.CurrentTable.Name
+		plan.add(table, "", ReasonRenamedTable, dialect.RenameTable(from, table), dialect.RenameTable(table, from))
+	case ChangeRenameColumn:
+		plan.add(table, column.Name, ReasonRenamedColumn, dialect.RenameColumn(table, current.Name, column.Name), dialect.RenameColumn(table, column.Name, current.Name))
+	case ChangeRebuildTable:
+		return m.planRebuild(ctx, dialect, plan, change)
+	case ChangeAddColumn:
+		if _, _, err := m.destruction(ctx, dialect, change); err != nil {
+			return err
+		}
+		plan.add(table, column.Name, ReasonNewColumn, dialect.AddColumn(table, column), dialect.DropColumn(table, column.Name))
+	case ChangeAlterType, ChangeSetNotNull:
+		if isLegacyTimeColumn(change) {
+			converted, err := m.convertLegacyTime(ctx, change)
+			if converted == nil {
+				plan.skip(table)
+				return err
+			}
+			change.Column, column = converted, converted
+		}
+		destruction, approved, err := m.destruction(ctx, dialect, change)
 		if err != nil {
 			return err
 		}
+		if change.Kind == ChangeAlterType {
+			plan.add(table, column.Name, ReasonTypeChange, dialect.AlterType(table, column), dialect.AlterType(table, current))
+		} else {
+			plan.add(table, column.Name, ReasonNewConstraint, dialect.AddConstraint(table, column, "not null"), "")
+		}
+		if destruction != "" {
+			plan.classify(destruction, approved)
+		}
+	case ChangeDropNotNull:
+		plan.add(table, column.Name, ReasonRemovedConstraint, dialect.DropNotNull(table, column), dialect.AddConstraint(table, current, "not null"))
+	case ChangeAddUnique:
+		plan.add(table, column.Name, ReasonNewConstraint, dialect.AddConstraint(table, column, "unique"), "")
+	case ChangeDropUnique:
+		plan.add(table, column.Name, ReasonRemovedConstraint, dialect.DropUnique(table, change.CurrentIndex.Name), dialect.AddConstraint(table, current, "unique"))
+	case ChangeSetDefault:
+		plan.add(table, column.Name, ReasonDefaultChange, dialect.SetDefault(table, column), "")
+	case ChangeDropDefault:
+		plan.add(table, column.Name, ReasonDefaultChange, dialect.DropDefault(table, column), dialect.SetDefault(table, current))
+	case ChangeAddIndex:
+		index := change.Index
+		plan.add(table, strings.Join(index.Columns, "_"), ReasonNewIndex, dialect.CreateIndex(table, index), dialect.DropIndex(table, index.Name))
+	case ChangeAlterIndex:
+		index, currentIndex := change.Index, change.CurrentIndex
+		name := strings.Join(index.Columns, "_")
+		plan.add(table, name, ReasonIndexChange, dialect.DropIndex(table, currentIndex.Name), dialect.CreateIndex(table, currentIndex))
+		plan.classify(DestructionDropIndex, false)
+		plan.add(table, name, ReasonIndexChange, dialect.CreateIndex(table, index), dialect.DropIndex(table, index.Name))
+	case ChangeRenameIndex:
+		index, legacy := change.Index, change.CurrentIndex
+		plan.add(table, strings.Join(index.Columns, "_"), ReasonIndexChange, dialect.RenameIndex(table, legacy.Name, index.Name), dialect.RenameIndex(table, index.Name, legacy.Name))
+	case ChangeDropIndex:
+		index := change.CurrentIndex
+		plan.add(table, strings.Join(index.Columns, "_"), ReasonRemovedIndex, dialect.DropIndex(table, index.Name), dialect.CreateIndex(table, index))
+		plan.classify(DestructionDropIndex, false)
+	case ChangeDropColumn:
+		plan.add(table, current.Name, ReasonRemovedColumn, dialect.DropColumn(table, current.Name), dialect.AddColumn(table, current))
+		plan.classify(DestructionDropColumn, false)
+		if m.Prune&PruneColumns == 0 {
+			plan.orphan()
+		}
+	case ChangeDropTable:
+		plan.add(table, "", ReasonRemovedTable, dialect.DropTable(table), dialect.CreateTable(change.Table))
+		plan.classify(DestructionDropTable, false)
+		if !m.pruneTables() {
+			plan.orphan()
+			break
+		}
+		// an empty checksum never matches, the table is created again if a model declares it later
+		plan.Models = append(plan.Models, ModelRevision{Table: table})
+	case ChangeAddForeignKey:
+		foreignKey := change.ForeignKey
+		plan.add(table, foreignKey.Column, ReasonNewForeignKey, dialect.AddForeignKey(table, foreignKey), dialect.DropForeignKey(table, foreignKey.Name))
+	case ChangeAlterForeignKey:
+		foreignKey, currentKey := change.ForeignKey, change.CurrentForeignKey
+		plan.add(table, foreignKey.Column, ReasonForeignKeyChange, dialect.DropForeignKey(table, currentKey.Name), dialect.AddForeignKey(table, currentKey))
+		plan.add(table, foreignKey.Column, ReasonForeignKeyChange, dialect.AddForeignKey(table, foreignKey), dialect.DropForeignKey(table, foreignKey.Name))
+	case ChangeDropForeignKey:
+		currentKey := change.CurrentForeignKey
+		plan.add(table, currentKey.Column, ReasonRemovedForeignKey, dialect.DropForeignKey(table, currentKey.Name), dialect.AddForeignKey(table, currentKey))
+	case ChangeAddCheck:
+		check := change.Check
+		plan.add(table, check.Name, ReasonNewConstraint, dialect.AddCheck(table, check), dialect.DropCheck(table, check.Name))
+	case ChangeDropCheck:
+		check := change.CurrentCheck
+		plan.add(table, check.Name, ReasonRemovedConstraint, dialect.DropCheck(table, check.Name), dialect.AddCheck(table, check))
 	}
 
 	return nil
 }
+
+// destruction classifies the change, it returns an error for lossy type
+// changes which are not allowed and for the NOT NULL columns without default
+// added to tables with rows, the rows would have no value
+func (m *Migrator) destruction(ctx context.Context, dialect Dialect, change Change) (Destruction, bool, error) {
+	column, current := change.Column, change.CurrentColumn
+	switch change.Kind {
+	case ChangeAlterType:
+		if !isLossyTypeChange(dialect, current.Type, column.Type) {
+			return "", false, nil
+		}
+		if !column.AllowLossy && !m.AllowLossy {
+			return "", false, &MigrationError{
+				Table:  change.Table.Name,
+				Column: column.Name,
+				Err: fmt.Errorf(
+					"%w from %s to %s, set the allow_lossy tag or WithLossyTypeChanges(true) to allow it",
+					ErrLossyTypeChange,
+					current.Type,
+					column.Type,
+				),
+			}
+		}
+		return DestructionNarrowingType, column.AllowLossy, nil
+	case ChangeAddColumn:
+		if !column.NotNull && !column.PrimaryKey || column.HasDefault || column.AutoIncrement {
+			return "", false, nil
+		}
+		rows, err := m.hasRows(ctx, dialect, change.Table.Name)
+		if err != nil || !rows {
+			return "", false, err
+		}
+		return "", false, &MigrationError{
+			Table:  change.Table.Name,
+			Column: column.Name,
+			Err:    fmt.Errorf("%w in a table with rows, give it a default or make it nullable", ErrNotNullWithoutDefault),
+		}
+	case ChangeSetNotNull:
+		nulls, err := m.hasNulls(ctx, dialect, change.Table.Name, column.Name)
+		if err != nil || !nulls {
+			return "", false, err
+		}
+		return DestructionNotNullWithNulls, false, nil
+	case ChangeDropColumn:
+		return DestructionDropColumn, false, nil
+	case ChangeAlterIndex, ChangeDropIndex:
+		return DestructionDropIndex, false, nil
+	case ChangeDropTable:
+		return DestructionDropTable, false, nil
+	}
+
+	return "", false, nil
+}
+
+// isPrunable reports whether the table is managed by the migrator: its name
+// starts with the table prefix and it is not the history table
+func (m *Migrator) isPrunable(table string) bool {
+	return strings.HasPrefix(table, m.tablePrefix()) && table != m.HistoryTable
+}
+
+// tablePrefix returns the prefix of the table names
+func (m *Migrator) tablePrefix() string {
+	if m.SnakeCase {
+		return toSnakeCase(m.TablePrefix)
+	}
+
+	return m.TablePrefix
+}
+
+// pruneTables reports whether the orphaned tables are dropped. Without table
+// prefix every table of the schema is orphaned, they are only dropped by the
+// DestructiveAllow policy.
+func (m *Migrator) pruneTables() bool {
+	return m.Prune&PruneTables != 0 && (m.tablePrefix() != "" || m.DestructivePolicy == DestructiveAllow)
+}
+
+// hasNulls reports whether the column has NULL values, plans built without
+// database assume it has none
+func (m *Migrator) hasNulls(ctx context.Context, dialect Dialect, table, column string) (bool, error) {
+	if m.DB == nil {
+		return false, nil
+	}
+	var count int64
+	query := fmt.Sprintf(
+		"SELECT COUNT(*) FROM %s WHERE %s IS NULL;",
+		dialect.QuoteIdentifier(table),
+		dialect.QuoteIdentifier(column),
+	)
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+
+	return count > 0, err
+}
+
+// hasRows reports whether the table has rows, plans built without database
+// assume it has none
+func (m *Migrator) hasRows(ctx context.Context, dialect Dialect, table string) (bool, error) {
+	if m.DB == nil {
+		return false, nil
+	}
+	var count int64
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s;", dialect.QuoteIdentifier(table))
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+
+	return count > 0, err
+}
+
+// MigrateModels plans and applies the migration of the models.
+func (m *Migrator) MigrateModels(models ...interface{}) error {
+	return m.MigrateModelsContext(context.Background(), models...)
+}
+
+// MigrateModelsContext is MigrateModels with a context used by every
+// introspection query and statement, so deployments can enforce timeouts and
+// cancel migrations blocked on locks.
+func (m *Migrator) MigrateModelsContext(ctx context.Context, models ...interface{}) error {
+	plan, err := m.PlanContext(ctx, models...)
+	if err != nil {
+		return err
+	}
+
+	return m.ApplyContext(ctx, plan)
+}
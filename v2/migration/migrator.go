@@ -1,59 +1,114 @@
 package migration
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
 
 const (
-	DBDriverPostgres DBDriver = iota
-	DBDriverMySQL
+	DBDriverPostgres  DBDriver = "postgres"
+	DBDriverMySQL     DBDriver = "mysql"
+	DBDriverSQLite    DBDriver = "sqlite"
+	DBDriverMariaDB   DBDriver = "mariadb"
+	DBDriverMSSQL     DBDriver = "mssql"
+	DBDriverCockroach DBDriver = "cockroach"
 )
 
-type DBDriver int
+const (
+	// TxPerRun applies the whole migration in a single transaction.
+	TxPerRun TxMode = iota
+	// TxPerModel applies each model in its own transaction.
+	TxPerModel
+	// TxDisabled applies the statements without transaction.
+	TxDisabled
+)
+
+const (
+	// DestructiveWarn prints the destructive changes and applies them.
+	DestructiveWarn DestructivePolicy = iota
+	// DestructiveDeny refuses to apply migrations with destructive changes.
+	DestructiveDeny
+	// DestructiveAllow applies destructive changes silently.
+	DestructiveAllow
+)
+
+const (
+	// PruneColumns drops the columns missing from the models.
+	PruneColumns PruneMode = 1 << iota
+	// PruneTables drops the tables prefixed by TablePrefix which are not
+	// declared by a model. Without TablePrefix they are only dropped by the
+	// DestructiveAllow policy.
+	PruneTables
+)
+
+// PruneMode selects the database objects missing from the models which are
+// dropped, they are only reported by default.
+type PruneMode int
+
+// DestructivePolicy configures how changes which may lose data are handled.
+type DestructivePolicy int
+
+// TxMode configures the transactions used by dialects supporting
+// transactional DDL.
+type TxMode int
+
+// DBDriver is the name of a registered Dialect.
+type DBDriver string
 
 func (d DBDriver) String() string {
-	switch d {
-	case DBDriverPostgres:
-		return "postgres"
-	case DBDriverMySQL:
-		return "mysql"
-	default:
-		return "mysql"
-	}
+	return string(d)
 }
 
 func NewDBDriver(driver string) DBDriver {
-	switch driver {
-	case "postgres":
-		return DBDriverPostgres
-	case "mysql":
-		return DBDriverMySQL
-	default:
-		return DBDriverMySQL
-	}
+	return DBDriver(driver)
 }
 
 type Options struct {
 	Driver            DBDriver
+	Dialect           Dialect
 	SnakeCase         bool
 	DB                *sql.DB
 	DefaultTextSize   uint8
 	IgnoreForeignKeys bool
 	TablePrefix       string
+	HistoryTable      string
+	Transaction       TxMode
+	AllowLossy        bool
+	DestructivePolicy DestructivePolicy
+	Prune             PruneMode
+	Strict            bool
+	Logger            *slog.Logger
+	Hooks             Hooks
+	NullableByDefault bool
 }
 
 type OptFunc func(*Options)
 
 var defaultOptions = Options{
+	Driver:            DBDriverPostgres,
 	SnakeCase:         true,
 	DB:                nil,
 	DefaultTextSize:   255,
 	IgnoreForeignKeys: true,
 	TablePrefix:       "",
+	HistoryTable:      "schema_migrations",
+	Transaction:       TxPerRun,
 }
 
 func SetDriver(driver string) OptFunc {
 	return func(opts *Options) {
 		d := NewDBDriver(driver)
 		opts.Driver = d
+		opts.Dialect = nil
+	}
+}
+
+// SetDialect uses dialect instead of the dialect registered for the driver.
+func SetDialect(dialect Dialect) OptFunc {
+	return func(opts *Options) {
+		opts.Driver = NewDBDriver(dialect.Name())
+		opts.Dialect = dialect
 	}
 }
 
@@ -91,13 +146,99 @@ func SetTablePrefix(prefix string) OptFunc {
 	}
 }
 
+// SetHistoryTable sets the name of the table recording the applied
+// migrations, an empty name disables the history.
+func SetHistoryTable(table string) OptFunc {
+	return func(opts *Options) {
+		opts.HistoryTable = table
+	}
+}
+
+// WithTransaction sets how migrations are wrapped in transactions, it is
+// ignored by dialects without transactional DDL.
+func WithTransaction(mode TxMode) OptFunc {
+	return func(opts *Options) {
+		opts.Transaction = mode
+	}
+}
+
+// WithLossyTypeChanges allows type changes which may lose data, like
+// narrowing a column or converting a text column to a number.
+func WithLossyTypeChanges(allow bool) OptFunc {
+	return func(opts *Options) {
+		opts.AllowLossy = allow
+	}
+}
+
+// WithDestructivePolicy sets the policy applied to changes which may lose
+// data, like dropping columns or indexes, narrowing types or adding NOT NULL
+// to columns with NULL values.
+func WithDestructivePolicy(policy DestructivePolicy) OptFunc {
+	return func(opts *Options) {
+		opts.DestructivePolicy = policy
+	}
+}
+
+// WithPrune drops the columns or tables missing from the models, like
+// WithPrune(PruneColumns|PruneTables). Drops are destructive changes checked
+// by the destructive policy.
+func WithPrune(mode PruneMode) OptFunc {
+	return func(opts *Options) {
+		opts.Prune = mode
+	}
+}
+
+// WithStrict returns the warnings about the models, like invalid constraints,
+// references or index options, as errors instead of ignoring the invalid
+// values.
+func WithStrict(strict bool) OptFunc {
+	return func(opts *Options) {
+		opts.Strict = strict
+	}
+}
+
+// WithNullableByDefault makes the columns nullable unless they have the not
+// null constraint. By default only the pointer and sql.Null fields are
+// nullable.
+func WithNullableByDefault(nullable bool) OptFunc {
+	return func(opts *Options) {
+		opts.NullableByDefault = nullable
+	}
+}
+
+// WithLogger sets the logger of the executed statements and of the warnings,
+// slog.Default() is used when it is not set.
+func WithLogger(logger *slog.Logger) OptFunc {
+	return func(opts *Options) {
+		opts.Logger = logger
+	}
+}
+
+// WithHooks sets the hooks called around every statement and model of the
+// migrations.
+func WithHooks(hooks Hooks) OptFunc {
+	return func(opts *Options) {
+		opts.Hooks = hooks
+	}
+}
+
 type Migrator struct {
 	Driver            DBDriver
+	Dialect           Dialect
 	SnakeCase         bool
 	DB                *sql.DB
 	DefaultTextSize   uint8
 	IgnoreForeignKeys bool
 	TablePrefix       string
+	HistoryTable      string
+	Transaction       TxMode
+	AllowLossy        bool
+	DestructivePolicy DestructivePolicy
+	Prune             PruneMode
+	Strict            bool
+	Logger            *slog.Logger
+	Hooks             Hooks
+	NullableByDefault bool
 }
 
 func NewMigrator(opts ...OptFunc) *Migrator {
@@ -105,14 +246,51 @@ func NewMigrator(opts ...OptFunc) *Migrator {
 	for _, fn := range opts {
 		fn(&o)
 	}
+	if o.Dialect == nil {
+		// unknown drivers are reported by MigrateModels
+		o.Dialect, _ = lookupDialect(o.Driver.String())
+	}
 	migrator := Migrator{
 		Driver:            o.Driver,
+		Dialect:           o.Dialect,
 		DB:                o.DB,
 		SnakeCase:         o.SnakeCase,
 		DefaultTextSize:   o.DefaultTextSize,
 		IgnoreForeignKeys: o.IgnoreForeignKeys,
 		TablePrefix:       o.TablePrefix,
+		HistoryTable:      o.HistoryTable,
+		Transaction:       o.Transaction,
+		AllowLossy:        o.AllowLossy,
+		DestructivePolicy: o.DestructivePolicy,
+		Prune:             o.Prune,
+		Strict:            o.Strict,
+		Logger:            o.Logger,
+		Hooks:             o.Hooks,
+		NullableByDefault: o.NullableByDefault,
 	}
 
 	return &migrator
 }
+
+func (m *Migrator) dialect() (Dialect, error) {
+	if m.Dialect != nil {
+		return m.Dialect, nil
+	}
+
+	return lookupDialect(m.Driver.String())
+}
+
+// dialectContext returns the dialect for the version of the database server
+// when the dialect depends on it.
+func (m *Migrator) dialectContext(ctx context.Context) (Dialect, error) {
+	dialect, err := m.dialect()
+	if err != nil {
+		return nil, err
+	}
+	detector, ok := dialect.(VersionDetector)
+	if !ok || m.DB == nil {
+		return dialect, nil
+	}
+
+	return detector.DetectVersion(ctx, m.DB)
+}
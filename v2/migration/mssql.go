@@ -0,0 +1,531 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterDialect(DBDriverMSSQL.String(), &mssqlDialect{})
+}
+
+// mssqlDialect generates the statements of SQL Server. Default values are
+// named constraints which must be dropped before their column is dropped,
+// converted or given another default value.
+type mssqlDialect struct{}
+
+func (d *mssqlDialect) Name() string {
+	return DBDriverMSSQL.String()
+}
+
+func (d *mssqlDialect) Types() *TypeRegistry {
+	return mssqlTypes
+}
+
+// TimeType returns DATETIME2 for timestamps unless they are declared with
+// time zone, which are stored with their offset by DATETIMEOFFSET columns.
+// SQL Server has no time of day with time zone.
+func (d *mssqlDialect) TimeType(timeType TimeType) string {
+	precision := timePrecision(timeType, 7)
+	switch timeType.Kind {
+	case TimeDate:
+		return "DATE"
+	case TimeOfDay:
+		return "TIME" + precision
+	default:
+		if timeType.TimeZone == TimeZoneWith {
+			return "DATETIMEOFFSET" + precision
+		}
+		return "DATETIME2" + precision
+	}
+}
+
+func (d *mssqlDialect) FormatDefault(column *Column) string {
+	value := column.Default
+	if isTextType(column.Type) {
+		return "N'" + strings.ReplaceAll(value, "'", "''") + "'"
+	} else if strings.Contains(value, "uuid") {
+		return "newid()"
+	} else if strings.EqualFold(value, "now()") && strings.HasPrefix(strings.ToUpper(column.Type), "DATETIMEOFFSET") {
+		return "sysdatetimeoffset()"
+	} else if strings.EqualFold(value, "now()") {
+		return "sysdatetime()"
+	}
+	if strings.EqualFold(column.Type, "BIT") {
+		switch strings.ToLower(value) {
+		case "true":
+			return "1"
+		case "false":
+			return "0"
+		}
+	}
+
+	return value
+}
+
+func (d *mssqlDialect) TransactionalDDL() bool {
+	return true
+}
+
+// defaultConstraintName returns the name of the default constraint of the
+// column, SQL Server generates a random name when it is not set
+func defaultConstraintName(table, column string) string {
+	return fmt.Sprintf("DF_%s_%s", table, column)
+}
+
+// columnDefinition returns the full definition of the column used by CREATE
+// TABLE and ADD
+func (d *mssqlDialect) columnDefinition(table string) func(column *Column) string {
+	return func(column *Column) string {
+		definition := column.Type
+		if column.AutoIncrement {
+			definition += " IDENTITY(1,1)"
+		}
+		if column.NotNull || column.PrimaryKey {
+			definition += " NOT NULL"
+		}
+		if column.HasDefault {
+			definition += fmt.Sprintf(" CONSTRAINT %s DEFAULT %s", defaultConstraintName(table, column.Name), column.Default)
+		}
+
+		return definition
+	}
+}
+
+// ifNotExists guards the statement creating the table, SQL Server has no
+// CREATE TABLE IF NOT EXISTS
+func (d *mssqlDialect) ifNotExists(table, statement string) string {
+	return fmt.Sprintf(
+		"IF OBJECT_ID(N'%s', N'U') IS NULL\n%s",
+		table,
+		strings.Replace(statement, "CREATE TABLE IF NOT EXISTS", "CREATE TABLE", 1),
+	)
+}
+
+func (d *mssqlDialect) CreateTable(table *Table) string {
+	return d.ifNotExists(table.Name, createTable(table, d.columnDefinition(table.Name)))
+}
+
+func (d *mssqlDialect) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE %s;", table)
+}
+
+func (d *mssqlDialect) RenameTable(from, to string) string {
+	return fmt.Sprintf("EXEC sp_rename N'%s', N'%s';", from, to)
+}
+
+func (d *mssqlDialect) AddColumn(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD %s %s;",
+		table,
+		column.Name,
+		d.columnDefinition(table)(column),
+	)
+}
+
+// dropDefault returns the statements dropping the default constraint of the
+// column whatever its name, the constraints created by other tools or by
+// previous names of the table are not named DF_<table>_<column>
+func (d *mssqlDialect) dropDefault(table, column string) string {
+	return fmt.Sprintf(
+		`DECLARE @default_constraint sysname = (SELECT dc.name FROM sys.default_constraints dc
+	JOIN sys.columns c ON c.object_id = dc.parent_object_id AND c.column_id = dc.parent_column_id
+	WHERE dc.parent_object_id = OBJECT_ID(N'%s') AND c.name = N'%s');
+IF @default_constraint IS NOT NULL EXEC(N'ALTER TABLE %s DROP CONSTRAINT ' + @default_constraint);
+`,
+		table,
+		column,
+		table,
+	)
+}
+
+func (d *mssqlDialect) DropColumn(table, column string) string {
+	return d.dropDefault(table, column) + fmt.Sprintf(
+		"ALTER TABLE %s DROP COLUMN %s;",
+		table,
+		column,
+	)
+}
+
+func (d *mssqlDialect) RenameColumn(table, from, to string) string {
+	return fmt.Sprintf(
+		"EXEC sp_rename N'%s.%s', N'%s', N'COLUMN';",
+		table,
+		from,
+		to,
+	)
+}
+
+// alterColumn returns the ALTER COLUMN statement, the nullability is reset
+// when it is not repeated
+func (d *mssqlDialect) alterColumn(table string, column *Column) string {
+	nullable := "NULL"
+	if column.NotNull || column.PrimaryKey {
+		nullable = "NOT NULL"
+	}
+
+	return fmt.Sprintf(
+		"ALTER TABLE %s ALTER COLUMN %s %s %s;",
+		table,
+		column.Name,
+		column.Type,
+		nullable,
+	)
+}
+
+// AlterType drops the default constraint of the column, which prevents type
+// changes, and adds it back after the conversion. SQL Server converts the
+// values itself and ignores the Using expression.
+func (d *mssqlDialect) AlterType(table string, column *Column) string {
+	statement := d.dropDefault(table, column.Name) + d.alterColumn(table, column)
+	if column.HasDefault {
+		statement += "\n" + d.addDefault(table, column)
+	}
+
+	return statement
+}
+
+func (d *mssqlDialect) AddConstraint(table string, column *Column, constraint string) string {
+	if constraint == "unique" {
+		return fmt.Sprintf(
+			"ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s);",
+			table,
+			uniqueConstraintName(table, column.Name),
+			column.Name,
+		)
+	}
+
+	return d.alterColumn(table, column)
+}
+
+func (d *mssqlDialect) DropNotNull(table string, column *Column) string {
+	return d.alterColumn(table, column)
+}
+
+func (d *mssqlDialect) addDefault(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD CONSTRAINT %s DEFAULT %s FOR %s;",
+		table,
+		defaultConstraintName(table, column.Name),
+		column.Default,
+		column.Name,
+	)
+}
+
+// SetDefault replaces the default constraint of the column, SQL Server cannot
+// alter a default constraint
+func (d *mssqlDialect) SetDefault(table string, column *Column) string {
+	return d.dropDefault(table, column.Name) + d.addDefault(table, column)
+}
+
+func (d *mssqlDialect) DropDefault(table string, column *Column) string {
+	return strings.TrimSuffix(d.dropDefault(table, column.Name), "\n")
+}
+
+func (d *mssqlDialect) DropUnique(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP CONSTRAINT %s;",
+		table,
+		name,
+	)
+}
+
+func (d *mssqlDialect) AddCheck(table string, check *Check) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s);",
+		table,
+		check.Name,
+		check.Expression,
+	)
+}
+
+func (d *mssqlDialect) DropCheck(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP CONSTRAINT %s;",
+		table,
+		name,
+	)
+}
+
+func (d *mssqlDialect) CreateIndex(table string, index *IndexDef) string {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+
+	return fmt.Sprintf(
+		"CREATE %sINDEX %s ON %s (%s);",
+		unique,
+		index.Name,
+		table,
+		strings.Join(index.Columns, ", "),
+	)
+}
+
+func (d *mssqlDialect) RenameIndex(table, from, to string) string {
+	return fmt.Sprintf(
+		"EXEC sp_rename N'%s.%s', N'%s', N'INDEX';",
+		table,
+		from,
+		to,
+	)
+}
+
+func (d *mssqlDialect) DropIndex(table, index string) string {
+	return fmt.Sprintf(
+		"DROP INDEX %s ON %s;",
+		index,
+		table,
+	)
+}
+
+// normalizeMSSQLType converts sys.types names to the datatypes of the type
+// registry, max_length is in bytes and -1 for MAX
+func normalizeMSSQLType(name string, maxLength, precision, scale int) string {
+	t := strings.ToUpper(name)
+	switch t {
+	case "DECIMAL", "NUMERIC":
+		return fmt.Sprintf("%s(%d,%d)", t, precision, scale)
+	case "TIME", "DATETIME2", "DATETIMEOFFSET":
+		// the scale is the precision of the seconds, 7 by default
+		if scale != 7 {
+			return fmt.Sprintf("%s(%d)", t, scale)
+		}
+		return t
+	case "NVARCHAR", "NCHAR", "VARCHAR", "CHAR", "VARBINARY", "BINARY":
+		if maxLength == -1 {
+			return t + "(MAX)"
+		}
+		if strings.HasPrefix(t, "N") {
+			maxLength /= 2
+		}
+		return fmt.Sprintf("%s(%d)", t, maxLength)
+	default:
+		return t
+	}
+}
+
+// unwrapMSSQLExpression removes the parentheses SQL Server adds around
+// default and check expressions
+func unwrapMSSQLExpression(expression string) string {
+	for strings.HasPrefix(expression, "(") && strings.HasSuffix(expression, ")") {
+		depth := 0
+		for i, c := range expression {
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				depth--
+			}
+			// the first parenthesis is closed before the end
+			if depth == 0 && i < len(expression)-1 {
+				return expression
+			}
+		}
+		expression = expression[1 : len(expression)-1]
+	}
+
+	return expression
+}
+
+// normalizeMSSQLDefault converts the definition of a default constraint to
+// the expressions returned by FormatDefault
+func normalizeMSSQLDefault(value, datatype string) string {
+	value = unwrapMSSQLExpression(value)
+	if isTextType(datatype) && strings.HasPrefix(value, "'") {
+		value = "N" + value
+	}
+
+	return value
+}
+
+func (d *mssqlDialect) TableExists(ctx context.Context, q Queryer, table string) (bool, error) {
+	query := `SELECT name FROM sys.tables
+				WHERE schema_id = SCHEMA_ID() AND name = @p1;`
+	var name string
+	err := q.QueryRowContext(ctx, query, table).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (d *mssqlDialect) CreateHistoryTable(table string) string {
+	return d.ifNotExists(table, fmt.Sprintf(
+		`CREATE TABLE %s
+(
+		version VARCHAR(32) NOT NULL,
+		table_name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at DATETIME2 NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		statements NVARCHAR(MAX) NOT NULL,
+		PRIMARY KEY (version, table_name)
+);`,
+		table,
+	))
+}
+
+func (d *mssqlDialect) Placeholder(n int) string {
+	return fmt.Sprintf("@p%d", n)
+}
+
+func (d *mssqlDialect) QuoteIdentifier(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+func (d *mssqlDialect) AddForeignKey(table string, foreignKey *ForeignKey) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s) ON DELETE %s ON UPDATE %s;",
+		table,
+		foreignKey.Name,
+		foreignKey.Column,
+		foreignKey.RefTable,
+		foreignKey.RefColumn,
+		foreignKey.OnDelete,
+		foreignKey.OnUpdate,
+	)
+}
+
+func (d *mssqlDialect) DropForeignKey(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP CONSTRAINT %s;",
+		table,
+		name,
+	)
+}
+
+func (d *mssqlDialect) Inspect(ctx context.Context, q Queryer) (*Schema, error) {
+	r := newSchemaReader(ctx, q)
+	err := r.readTables(`SELECT name FROM sys.tables
+				WHERE schema_id = SCHEMA_ID()
+				ORDER BY name;`)
+	if err != nil {
+		return nil, err
+	}
+	err = r.query(`SELECT t.name, c.name, ty.name, c.max_length, c.precision, c.scale, c.is_nullable, c.is_identity, dc.definition
+				FROM sys.columns c
+				JOIN sys.tables t ON t.object_id = c.object_id
+				JOIN sys.types ty ON ty.user_type_id = c.user_type_id
+				LEFT JOIN sys.default_constraints dc ON dc.object_id = c.default_object_id
+				WHERE t.schema_id = SCHEMA_ID()
+				ORDER BY t.name, c.column_id;`, func(rows *sql.Rows) error {
+		var table, dataType string
+		var column Column
+		var maxLength, precision, scale int
+		var nullable bool
+		var defaultValue sql.NullString
+		err := rows.Scan(&table, &column.Name, &dataType, &maxLength, &precision, &scale, &nullable, &column.AutoIncrement, &defaultValue)
+		if err != nil {
+			return err
+		}
+		column.Type = normalizeMSSQLType(dataType, maxLength, precision, scale)
+		column.NotNull = !nullable
+		column.HasDefault = defaultValue.Valid
+		if column.HasDefault {
+			column.Default = normalizeMSSQLDefault(defaultValue.String, column.Type)
+		}
+		if t, ok := r.tables[table]; ok {
+			t.Columns = append(t.Columns, &column)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.query(`SELECT t.name, i.name, i.is_unique, i.is_primary_key, c.name
+				FROM sys.indexes i
+				JOIN sys.tables t ON t.object_id = i.object_id
+				JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+				JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+				WHERE t.schema_id = SCHEMA_ID() AND i.type > 0 AND ic.is_included_column = 0
+				ORDER BY t.name, i.name, ic.key_ordinal;`, func(rows *sql.Rows) error {
+		var table, index, column string
+		var unique, primary bool
+		err := rows.Scan(&table, &index, &unique, &primary, &column)
+		if err != nil {
+			return err
+		}
+		r.addIndexColumn(table, index, column, unique, primary)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// primary keys and single column unique indexes make their column unique
+	for _, table := range r.schema.Tables {
+		for _, name := range table.PrimaryKey {
+			if column := table.Column(name); column != nil {
+				column.PrimaryKey = true
+				column.Unique = len(table.PrimaryKey) == 1
+			}
+		}
+		for _, index := range table.Indexes {
+			if !index.Unique || len(index.Columns) != 1 {
+				continue
+			}
+			if column := table.Column(index.Columns[0]); column != nil {
+				column.Unique = true
+			}
+		}
+	}
+	err = r.query(`SELECT t.name, fk.name, c.name, rt.name, rc.name,
+				fk.delete_referential_action_desc, fk.update_referential_action_desc
+				FROM sys.foreign_keys fk
+				JOIN sys.tables t ON t.object_id = fk.parent_object_id
+				JOIN sys.tables rt ON rt.object_id = fk.referenced_object_id
+				JOIN sys.foreign_key_columns fkc ON fkc.constraint_object_id = fk.object_id AND fkc.constraint_column_id = 1
+				JOIN sys.columns c ON c.object_id = fkc.parent_object_id AND c.column_id = fkc.parent_column_id
+				JOIN sys.columns rc ON rc.object_id = fkc.referenced_object_id AND rc.column_id = fkc.referenced_column_id
+				WHERE t.schema_id = SCHEMA_ID()
+				ORDER BY t.name, fk.name;`, func(rows *sql.Rows) error {
+		var table string
+		var foreignKey ForeignKey
+		err := rows.Scan(
+			&table,
+			&foreignKey.Name,
+			&foreignKey.Column,
+			&foreignKey.RefTable,
+			&foreignKey.RefColumn,
+			&foreignKey.OnDelete,
+			&foreignKey.OnUpdate,
+		)
+		if err != nil {
+			return err
+		}
+		foreignKey.OnDelete, _ = normalizeReferentialAction(foreignKey.OnDelete)
+		foreignKey.OnUpdate, _ = normalizeReferentialAction(foreignKey.OnUpdate)
+		if t, ok := r.tables[table]; ok {
+			t.ForeignKeys = append(t.ForeignKeys, &foreignKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.query(`SELECT t.name, cc.name, cc.definition
+				FROM sys.check_constraints cc
+				JOIN sys.tables t ON t.object_id = cc.parent_object_id
+				WHERE t.schema_id = SCHEMA_ID()
+				ORDER BY t.name, cc.name;`, func(rows *sql.Rows) error {
+		var table string
+		var check Check
+		err := rows.Scan(&table, &check.Name, &check.Expression)
+		if err != nil {
+			return err
+		}
+		check.Expression = unwrapMSSQLExpression(check.Expression)
+		if t, ok := r.tables[table]; ok {
+			t.Checks = append(t.Checks, &check)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.schema, nil
+}
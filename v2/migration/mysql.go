@@ -1,185 +1,473 @@
 package migration
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"reflect"
+	"regexp"
 	"strings"
 )
 
-type Statistic struct {
-	NonUnique bool
-	IndexName string
-	Nullable  bool
+func init() {
+	RegisterDialect(DBDriverMySQL.String(), &mysqlDialect{})
 }
 
-func (m *Migrator) createMySqlSchemas(table string, model reflect.Type) error {
-	// ID must be first property of model structure
-	IDfield := model.Field(0)
+type mysqlDialect struct{}
 
-	tableMigration := fmt.Sprintf(
-		"CREATE TABLE IF NOT EXISTS %s\n(\n",
-		table,
-	)
-	tableMigration += "		"
-	tableMigration += toSnakeCase(IDfield.Name) + " "
-	pkType := m.convertType(IDfield.Type.String())
-	tableMigration += pkType + " "
-	if pkType == "binary(16)" {
-		tableMigration += "UNIQUE NOT NULL DEFAULT (UUID_TO_BIN(UUID()))"
-	} else {
-		idValues := parseTag(IDfield.Tag.Get("migration"))
-		for _, constraint := range strings.Split(idValues["constraints"], ",") {
-			tableMigration += constraint + " "
+func (d *mysqlDialect) Name() string {
+	return DBDriverMySQL.String()
+}
+
+func (d *mysqlDialect) Types() *TypeRegistry {
+	return mysqlTypes
+}
+
+// TimeType returns DATETIME for timestamps unless they are declared with time
+// zone, which are stored in UTC by TIMESTAMP columns. The default precision
+// is the microsecond of go times.
+func (d *mysqlDialect) TimeType(timeType TimeType) string {
+	if timeType.Precision < 0 {
+		timeType.Precision = 6
+	}
+	precision := timePrecision(timeType, 0)
+	switch timeType.Kind {
+	case TimeDate:
+		return "DATE"
+	case TimeOfDay:
+		return "TIME" + precision
+	default:
+		if timeType.TimeZone == TimeZoneWith {
+			return "TIMESTAMP" + precision
 		}
+		return "DATETIME" + precision
+	}
+}
+
+var mysqlCurrentTimestamp = regexp.MustCompile(`(?i)^current_timestamp(?:\((\d*)\))?`)
+
+// currentTimestamp returns the CURRENT_TIMESTAMP expression with the
+// precision of the datatype, MySQL refuses defaults less precise than their
+// column
+func currentTimestamp(datatype string) string {
+	d := strings.ToUpper(datatype)
+	if match := sqlTypeSize.FindStringSubmatch(d); match != nil &&
+		(strings.HasPrefix(d, "DATETIME") || strings.HasPrefix(d, "TIMESTAMP")) {
+		return "CURRENT_TIMESTAMP(" + match[1] + ")"
 	}
-	tableMigration += "\n);"
-	_, err := m.DB.Exec(tableMigration)
 
-	return err
+	return "CURRENT_TIMESTAMP"
 }
 
-func (m *Migrator) generateMySqlColumnMigration(table string, params map[string]string) error {
-	infos, err := m.getMySqlSchemaInformation(table, params["column"])
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
+// normalizeCurrentTimestamp converts the CURRENT_TIMESTAMP expressions of
+// information_schema to the expressions returned by currentTimestamp
+func normalizeCurrentTimestamp(value string) (string, bool) {
+	match := mysqlCurrentTimestamp.FindStringSubmatch(value)
+	if match == nil {
+		return value, false
 	}
-	if infos != nil && convertSqlDataType(infos.Type) != strings.ToLower(params["type"]) {
-		query := fmt.Sprintf(
-			"ALTER TABLE %s DROP COLUMN %s;",
+	if match[1] == "" || match[1] == "0" {
+		return "CURRENT_TIMESTAMP", true
+	}
+
+	return "CURRENT_TIMESTAMP(" + match[1] + ")", true
+}
+
+func (d *mysqlDialect) FormatDefault(column *Column) string {
+	value := column.Default
+	if isTextType(column.Type) {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	} else if strings.Contains(value, "uuid") {
+		return "(UUID_TO_BIN(UUID()))"
+	} else if strings.EqualFold(value, "now()") {
+		return currentTimestamp(column.Type)
+	}
+	if strings.EqualFold(column.Type, "BOOL") {
+		switch strings.ToLower(value) {
+		case "true":
+			return "1"
+		case "false":
+			return "0"
+		}
+	}
+
+	return value
+}
+
+// columnDefinition returns the full definition of the column used by CREATE
+// TABLE, ADD COLUMN and MODIFY
+func (d *mysqlDialect) columnDefinition(column *Column) string {
+	definition := column.Type
+	if column.NotNull || column.PrimaryKey {
+		definition += " NOT NULL"
+	}
+	if column.AutoIncrement {
+		definition += " AUTO_INCREMENT"
+	}
+	if column.HasDefault {
+		definition += " DEFAULT " + column.Default
+	}
+
+	return definition
+}
+
+// TransactionalDDL returns false, MySQL commits implicitly DDL statements
+func (d *mysqlDialect) TransactionalDDL() bool {
+	return false
+}
+
+func (d *mysqlDialect) CreateTable(table *Table) string {
+	return createTable(table, d.columnDefinition)
+}
+
+func (d *mysqlDialect) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE %s;", table)
+}
+
+func (d *mysqlDialect) RenameTable(from, to string) string {
+	return fmt.Sprintf("RENAME TABLE %s TO %s;", from, to)
+}
+
+func (d *mysqlDialect) AddColumn(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD COLUMN %s %s;",
+		table,
+		column.Name,
+		d.columnDefinition(column),
+	)
+}
+
+func (d *mysqlDialect) DropColumn(table, column string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP COLUMN %s;",
+		table,
+		column,
+	)
+}
+
+func (d *mysqlDialect) RenameColumn(table, from, to string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s RENAME COLUMN %s TO %s;",
+		table,
+		from,
+		to,
+	)
+}
+
+// AlterType returns a MODIFY statement, MySQL converts the values itself and
+// ignores the Using expression
+func (d *mysqlDialect) AlterType(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s MODIFY COLUMN %s %s;",
+		table,
+		column.Name,
+		d.columnDefinition(column),
+	)
+}
+
+func (d *mysqlDialect) AddConstraint(table string, column *Column, constraint string) string {
+	if constraint == "unique" {
+		return fmt.Sprintf(
+			"ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s);",
 			table,
-			params["column"],
+			uniqueConstraintName(table, column.Name),
+			column.Name,
 		)
-		_, err = m.DB.Exec(query)
-		if err != nil {
-			return err
-		}
 	}
-	query := fmt.Sprintf(
-		"ALTER TABLE %s ADD COLUMN %s %s;\n",
+
+	return fmt.Sprintf(
+		"ALTER TABLE %s MODIFY COLUMN %s %s;",
 		table,
-		params["column"],
-		params["type"],
+		column.Name,
+		d.columnDefinition(column),
 	)
-	_, err = m.DB.Exec(query)
-	if err != nil {
-		rowExist := strings.Contains(err.Error(), "Duplicate column name")
-		if !rowExist {
-			return err
-		}
+}
+
+func (d *mysqlDialect) DropNotNull(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s MODIFY COLUMN %s %s;",
+		table,
+		column.Name,
+		d.columnDefinition(column),
+	)
+}
+
+func (d *mysqlDialect) SetDefault(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s MODIFY COLUMN %s %s;",
+		table,
+		column.Name,
+		d.columnDefinition(column),
+	)
+}
+
+func (d *mysqlDialect) DropDefault(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT;",
+		table,
+		column.Name,
+	)
+}
+
+// DropUnique drops the index of the unique constraint
+func (d *mysqlDialect) DropUnique(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP INDEX %s;",
+		table,
+		name,
+	)
+}
+
+func (d *mysqlDialect) AddCheck(table string, check *Check) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s);",
+		table,
+		check.Name,
+		check.Expression,
+	)
+}
+
+func (d *mysqlDialect) DropCheck(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP CHECK %s;",
+		table,
+		name,
+	)
+}
+
+func (d *mysqlDialect) CreateIndex(table string, index *IndexDef) string {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
 	}
-	infos, err = m.getMySqlSchemaInformation(table, params["column"])
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
-	}
-	constraints, hasConstraint := params["constraints"]
-	if hasConstraint {
-		for _, constraint := range strings.Split(constraints, ",") {
-			if !checkConstraint(constraint) {
-				fmt.Printf("[WARN] constraint %s is not valid and was ignored\n", constraint)
-				continue
-			}
-			if infos != nil {
-				if constraint == "not null" && infos.Null == "YES" {
-					continue
-				} else if constraint == "unique" && infos.Key == "UNI" {
-					continue
-				}
-			}
-			query = fmt.Sprintf(
-				"ALTER TABLE %s ",
-				table,
-			)
-			query += fmt.Sprintf(
-				"MODIFY %s %s %s;\n",
-				params["column"],
-				params["type"],
-				constraint,
-			)
-			_, err = m.DB.Exec(query)
-			if err != nil {
-				return err
-			}
-		}
+
+	return fmt.Sprintf(
+		"CREATE %sINDEX %s ON %s (%s);",
+		unique,
+		index.Name,
+		table,
+		strings.Join(index.Columns, ", "),
+	)
+}
+
+func (d *mysqlDialect) RenameIndex(table, from, to string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s RENAME INDEX %s TO %s;",
+		table,
+		from,
+		to,
+	)
+}
+
+func (d *mysqlDialect) DropIndex(table, index string) string {
+	return fmt.Sprintf(
+		"DROP INDEX %s ON %s;",
+		index,
+		table,
+	)
+}
+
+var mysqlIntDisplayWidth = regexp.MustCompile(`^((?:tiny|small|medium|big)?int)\(\d+\)`)
+
+// normalizeMySqlType converts information_schema column type to the
+// datatypes of the type registry
+func normalizeMySqlType(columnType string) string {
+	t := strings.ToLower(columnType)
+	if t == "tinyint(1)" {
+		return "bool"
 	}
-	infos, err = m.getMySqlSchemaInformation(table, params["column"])
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
+
+	return mysqlIntDisplayWidth.ReplaceAllString(t, "$1")
+}
+
+// normalizeMySqlColumn fills the column from its information_schema
+// description, the default value is normalized by normalizeDefault
+func normalizeMySqlColumn(column *Column, nullable, key, extra string, defaultValue sql.NullString, normalizeDefault func(column *Column, value, extra string)) {
+	column.Type = normalizeMySqlType(column.Type)
+	column.NotNull = nullable == "NO"
+	column.Unique = key == "UNI" || key == "PRI"
+	column.PrimaryKey = key == "PRI"
+	column.AutoIncrement = strings.Contains(extra, "auto_increment")
+	column.HasDefault = defaultValue.Valid
+	if column.HasDefault {
+		normalizeDefault(column, defaultValue.String, extra)
 	}
-	defaultValue, hasDefaultValue := params["default"]
-	if hasDefaultValue && defaultValue != infos.Default {
-		if strings.Contains(params["type"], "VARCHAR") || strings.Contains(params["type"], "TEXT") {
-			defaultValue = "'" + defaultValue + "'"
-		} else if strings.Contains(defaultValue, "uuid") {
-			defaultValue = "(UUID_TO_BIN(UUID()))"
-		}
-		query = fmt.Sprintf(
-			"ALTER TABLE %s MODIFY COLUMN %s %s DEFAULT %s;\n",
-			table,
-			params["column"],
-			params["type"],
-			defaultValue,
-		)
+}
 
-		_, err = m.DB.Exec(query)
-		if err != nil {
-			return err
-		}
+// normalizeMySqlDefault converts the information_schema default value to the
+// expressions returned by FormatDefault, MySQL returns unquoted literals
+func normalizeMySqlDefault(column *Column, value, extra string) {
+	column.Default = value
+	if timestamp, ok := normalizeCurrentTimestamp(value); ok {
+		column.Default = timestamp
+	} else if strings.Contains(extra, "DEFAULT_GENERATED") {
+		column.Default = "(" + value + ")"
+	} else if isTextType(column.Type) {
+		column.Default = "'" + strings.ReplaceAll(value, "'", "''") + "'"
 	}
-	_, isIndex := params["index"]
-	if isIndex {
-		query = fmt.Sprintf(
-			`SELECT NON_UNIQUE, INDEX_NAME, NULLABLE 
-					FROM information_schema.statistics 
-					WHERE table_name = '%s' AND column_name = '%s';`,
-			table,
-			params["column"],
-		)
-		var statistic Statistic
-		err = m.DB.QueryRow(query).Scan(&statistic.NonUnique, &statistic.IndexName, &statistic.Nullable)
-		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			return err
-		}
-		if errors.Is(err, sql.ErrNoRows) {
-			query = fmt.Sprintf(
-				"CREATE INDEX index_%s ON %s (%s);\n",
-				params["column"],
-				table,
-				params["column"],
-			)
-			_, err = m.DB.Exec(query)
-			if err != nil {
-				return err
-			}
-		}
+}
+
+func (d *mysqlDialect) TableExists(ctx context.Context, q Queryer, table string) (bool, error) {
+	query := `SELECT TABLE_NAME
+				FROM information_schema.TABLES
+				WHERE table_schema = DATABASE() AND table_name = ?;`
+	var name string
+	err := q.QueryRowContext(ctx, query, table).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
 	}
 
-	return nil
+	return err == nil, err
 }
 
-type MysqlTableInfo struct {
-	Field   string
-	Type    string
-	Null    string
-	Key     string
-	Extra   string
-	Default interface{}
+func (d *mysqlDialect) CreateHistoryTable(table string) string {
+	return fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s
+(
+		version VARCHAR(32) NOT NULL,
+		table_name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at DATETIME(6) NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		statements LONGTEXT NOT NULL,
+		PRIMARY KEY (version, table_name)
+);`,
+		table,
+	)
 }
 
-func (m *Migrator) getMySqlSchemaInformation(table, column string) (*MysqlTableInfo, error) {
-	query := fmt.Sprintf(
-		`SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, EXTRA, COLUMN_DEFAULT
-				FROM information_schema.COLUMNS
-				WHERE table_name = '%s' AND column_name = '%s' ;`,
+func (d *mysqlDialect) Placeholder(_ int) string {
+	return "?"
+}
+
+func (d *mysqlDialect) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (d *mysqlDialect) AddForeignKey(table string, foreignKey *ForeignKey) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s) ON DELETE %s ON UPDATE %s;",
 		table,
-		column,
+		foreignKey.Name,
+		foreignKey.Column,
+		foreignKey.RefTable,
+		foreignKey.RefColumn,
+		foreignKey.OnDelete,
+		foreignKey.OnUpdate,
+	)
+}
+
+func (d *mysqlDialect) DropForeignKey(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP FOREIGN KEY %s;",
+		table,
+		name,
 	)
-	var result MysqlTableInfo
-	err := m.DB.QueryRow(query).Scan(&result.Field, &result.Type, &result.Null, &result.Key, &result.Extra, &result.Default)
+}
+
+func (d *mysqlDialect) Inspect(ctx context.Context, q Queryer) (*Schema, error) {
+	return inspectMySql(ctx, q, normalizeMySqlDefault)
+}
+
+// inspectMySql reads the schema of MySQL and MariaDB databases, which only
+// differ by their default values
+func inspectMySql(ctx context.Context, q Queryer, normalizeDefault func(column *Column, value, extra string)) (*Schema, error) {
+	r := newSchemaReader(ctx, q)
+	err := r.readTables(`SELECT TABLE_NAME FROM information_schema.TABLES
+				WHERE table_schema = DATABASE() AND TABLE_TYPE = 'BASE TABLE'
+				ORDER BY TABLE_NAME;`)
+	if err != nil {
+		return nil, err
+	}
+	err = r.query(`SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, EXTRA, COLUMN_DEFAULT
+				FROM information_schema.COLUMNS
+				WHERE table_schema = DATABASE()
+				ORDER BY TABLE_NAME, ORDINAL_POSITION;`, func(rows *sql.Rows) error {
+		var table, nullable, key, extra string
+		var column Column
+		var defaultValue sql.NullString
+		err := rows.Scan(&table, &column.Name, &column.Type, &nullable, &key, &extra, &defaultValue)
+		if err != nil {
+			return err
+		}
+		normalizeMySqlColumn(&column, nullable, key, extra, defaultValue, normalizeDefault)
+		if t, ok := r.tables[table]; ok {
+			t.Columns = append(t.Columns, &column)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	err = r.query(`SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME
+				FROM information_schema.STATISTICS
+				WHERE table_schema = DATABASE()
+				ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX;`, func(rows *sql.Rows) error {
+		var table, index, column string
+		var nonUnique bool
+		err := rows.Scan(&table, &index, &nonUnique, &column)
+		if err != nil {
+			return err
+		}
+		r.addIndexColumn(table, index, column, !nonUnique, index == "PRIMARY")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.query(`SELECT kcu.TABLE_NAME, kcu.CONSTRAINT_NAME, kcu.COLUMN_NAME, kcu.REFERENCED_TABLE_NAME,
+				kcu.REFERENCED_COLUMN_NAME, rc.DELETE_RULE, rc.UPDATE_RULE
+				FROM information_schema.KEY_COLUMN_USAGE kcu
+				JOIN information_schema.REFERENTIAL_CONSTRAINTS rc
+					ON rc.CONSTRAINT_SCHEMA = kcu.CONSTRAINT_SCHEMA AND rc.CONSTRAINT_NAME = kcu.CONSTRAINT_NAME
+				WHERE kcu.TABLE_SCHEMA = DATABASE()
+				ORDER BY kcu.TABLE_NAME, kcu.CONSTRAINT_NAME, kcu.ORDINAL_POSITION;`, func(rows *sql.Rows) error {
+		var table string
+		var foreignKey ForeignKey
+		err := rows.Scan(
+			&table,
+			&foreignKey.Name,
+			&foreignKey.Column,
+			&foreignKey.RefTable,
+			&foreignKey.RefColumn,
+			&foreignKey.OnDelete,
+			&foreignKey.OnUpdate,
+		)
+		if err != nil {
+			return err
+		}
+		foreignKey.OnDelete, _ = normalizeReferentialAction(foreignKey.OnDelete)
+		foreignKey.OnUpdate, _ = normalizeReferentialAction(foreignKey.OnUpdate)
+		if t, ok := r.tables[table]; ok {
+			t.ForeignKeys = append(t.ForeignKeys, &foreignKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// CHECK_CONSTRAINTS is available since MySQL 8.0.16, the previous
+	// versions ignore the check constraints
+	err = r.query(`SELECT tc.TABLE_NAME, cc.CONSTRAINT_NAME, cc.CHECK_CLAUSE
+				FROM information_schema.TABLE_CONSTRAINTS tc
+				JOIN information_schema.CHECK_CONSTRAINTS cc
+					ON cc.CONSTRAINT_SCHEMA = tc.CONSTRAINT_SCHEMA AND cc.CONSTRAINT_NAME = tc.CONSTRAINT_NAME
+				WHERE tc.TABLE_SCHEMA = DATABASE() AND tc.CONSTRAINT_TYPE = 'CHECK'
+				ORDER BY tc.TABLE_NAME, cc.CONSTRAINT_NAME;`, func(rows *sql.Rows) error {
+		var table string
+		var check Check
+		err := rows.Scan(&table, &check.Name, &check.Expression)
+		if err != nil {
+			return err
+		}
+		if t, ok := r.tables[table]; ok {
+			t.Checks = append(t.Checks, &check)
+		}
+		return nil
+	})
+	if err != nil && !isUnknownTable(err) {
+		return nil, err
+	}
 
-	return &result, err
+	return r.schema, nil
 }
@@ -1,19 +1,160 @@
 package migration
 
-import "strings"
-
-func parseTag(tag string) map[string]string {
-	parsed := make(map[string]string)
-	items := strings.Split(tag, ";")
-	for _, item := range items {
-		s := strings.Split(item, ":")
-		if len(s) != 2 {
+import (
+	"fmt"
+	"strings"
+)
+
+// tagKeys are the keys of the migration tag, flags are the keys which can
+// be set without value
+var (
+	tagKeys = []string{
+		"type",
+		"constraints",
+		"default",
+		"index",
+		"unique_index",
+		"references",
+		"on_delete",
+		"on_update",
+		"using",
+		"allow_lossy",
+		"renamed_from",
+		"time",
+		"time_zone",
+	}
+	tagFlags = map[string]bool{
+		"index":        true,
+		"unique_index": true,
+		"allow_lossy":  true,
+		"time_zone":    true,
+	}
+)
+
+// parseTag parses a migration tag made of 'key:value' items separated by
+// semicolons. The value starts after the first colon of the item, it can be
+// quoted with single quotes to contain semicolons, a quote is escaped by
+// doubling it. Outside quotes a backslash escapes the next character. It
+// returns the values by key and the keys whose value was quoted.
+func parseTag(tag string) (map[string]string, map[string]bool, error) {
+	values := make(map[string]string)
+	quoted := make(map[string]bool)
+	runes := []rune(tag)
+	for i := 0; i < len(runes); i++ {
+		// key
+		start := i
+		for i < len(runes) && runes[i] != ':' && runes[i] != ';' {
+			i++
+		}
+		key := strings.TrimSpace(string(runes[start:i]))
+		hasValue := i < len(runes) && runes[i] == ':'
+		if key == "" {
+			if hasValue {
+				return nil, nil, fmt.Errorf("%w: missing key at position %d", ErrInvalidTag, start)
+			}
+			// empty item
 			continue
 		}
-		key := s[0]
-		value := s[1]
-		parsed[key] = value
+		if err := checkTagKey(key); err != nil {
+			return nil, nil, err
+		}
+		if _, duplicate := values[key]; duplicate {
+			return nil, nil, fmt.Errorf("%w: duplicate key %s", ErrInvalidTag, key)
+		}
+		if !hasValue {
+			if !tagFlags[key] {
+				return nil, nil, fmt.Errorf("%w: missing value of %s", ErrInvalidTag, key)
+			}
+			values[key] = ""
+			continue
+		}
+		// value
+		i++
+		for i < len(runes) && runes[i] == ' ' {
+			i++
+		}
+		var value strings.Builder
+		if i < len(runes) && runes[i] == '\'' {
+			quoted[key] = true
+			closed := false
+			for i++; i < len(runes); i++ {
+				if runes[i] != '\'' {
+					value.WriteRune(runes[i])
+					continue
+				}
+				if i+1 < len(runes) && runes[i+1] == '\'' {
+					value.WriteRune('\'')
+					i++
+					continue
+				}
+				closed = true
+				i++
+				break
+			}
+			if !closed {
+				return nil, nil, fmt.Errorf("%w: unterminated quoted value of %s", ErrInvalidTag, key)
+			}
+			for i < len(runes) && runes[i] == ' ' {
+				i++
+			}
+			if i < len(runes) && runes[i] != ';' {
+				return nil, nil, fmt.Errorf("%w: unexpected %q after the quoted value of %s", ErrInvalidTag, runes[i], key)
+			}
+		} else {
+			for ; i < len(runes) && runes[i] != ';'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+			}
+		}
+		values[key] = value.String()
+		if !quoted[key] {
+			values[key] = strings.TrimSpace(values[key])
+		}
+	}
+
+	return values, quoted, nil
+}
+
+// checkTagKey returns an error for the unknown keys, suggesting the closest
+// known key
+func checkTagKey(key string) error {
+	suggestion := ""
+	distance := 3
+	for _, known := range tagKeys {
+		if key == known {
+			return nil
+		}
+		if d := editDistance(key, known); d < distance {
+			suggestion, distance = known, d
+		}
+	}
+	if suggestion != "" {
+		return fmt.Errorf("%w: unknown key %s, did you mean %s?", ErrInvalidTag, key, suggestion)
+	}
+
+	return fmt.Errorf("%w: unknown key %s, expected one of %s", ErrInvalidTag, key, strings.Join(tagKeys, ", "))
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
 	}
 
-	return parsed
+	return previous[len(b)]
 }
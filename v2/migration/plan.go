@@ -0,0 +1,442 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Reason explains why a statement is part of a migration plan.
+type Reason string
+
+const (
+	ReasonNewTable      Reason = "new table"
+	ReasonNewColumn     Reason = "new column"
+	ReasonTypeChange    Reason = "type change"
+	ReasonNewConstraint Reason = "new constraint"
+	ReasonDefaultChange Reason = "default change"
+	ReasonNewIndex      Reason = "new index"
+	ReasonIndexChange   Reason = "index change"
+	ReasonRemovedIndex  Reason = "removed index"
+	ReasonRemovedColumn Reason = "removed column"
+	ReasonRemovedTable  Reason = "removed table"
+	ReasonRenamedColumn Reason = "renamed column"
+	ReasonRenamedTable  Reason = "renamed table"
+	ReasonRebuiltTable  Reason = "rebuilt table"
+
+	ReasonNewForeignKey     Reason = "new foreign key"
+	ReasonForeignKeyChange  Reason = "foreign key change"
+	ReasonRemovedForeignKey Reason = "removed foreign key"
+
+	// ReasonRemovedConstraint is used for the NOT NULL, unique and check
+	// constraints removed from the models.
+	ReasonRemovedConstraint Reason = "removed constraint"
+)
+
+// Destruction classifies the statements which may lose data.
+type Destruction string
+
+const (
+	DestructionDropTable        Destruction = "drop table"
+	DestructionDropColumn       Destruction = "drop column"
+	DestructionNarrowingType    Destruction = "narrowing type"
+	DestructionNotNullWithNulls Destruction = "not null on column with nulls"
+	DestructionDropIndex        Destruction = "drop index"
+)
+
+// Statement is a SQL statement of a migration plan. Undo is the statement
+// reverting it, it is empty when the statement cannot be reverted.
+// Destructive is set when the statement may lose data, Approved when the
+// model explicitly allows it.
+type Statement struct {
+	Table       string
+	Column      string
+	Reason      Reason
+	SQL         string
+	Undo        string
+	Destructive Destruction
+	Approved    bool
+}
+
+// MigrationPlan is the ordered list of statements required to migrate the
+// database to the models. Models lists the revisions recorded in the history
+// when the plan is applied, Unchanged the tables of the models skipped
+// because their revision was already applied. Orphans lists the statements
+// dropping the columns and tables missing from the models which are not
+// pruned, they are never executed.
+type MigrationPlan struct {
+	Driver     DBDriver
+	Statements []Statement
+	Models     []ModelRevision
+	Unchanged  []string
+	Orphans    []Statement
+}
+
+func (p *MigrationPlan) add(table, column string, reason Reason, query, undo string) {
+	p.Statements = append(p.Statements, Statement{
+		Table:  table,
+		Column: column,
+		Reason: reason,
+		SQL:    query,
+		Undo:   undo,
+	})
+}
+
+// classify marks the last statement added to the plan as destructive.
+func (p *MigrationPlan) classify(destruction Destruction, approved bool) {
+	last := &p.Statements[len(p.Statements)-1]
+	last.Destructive = destruction
+	last.Approved = approved
+}
+
+// orphan moves the last statement added to the plan to the orphans.
+func (p *MigrationPlan) orphan() {
+	p.Orphans = append(p.Orphans, p.Statements[len(p.Statements)-1])
+	p.Statements = p.Statements[:len(p.Statements)-1]
+}
+
+// skip removes the revision of the model from the plan, the changes of the
+// model skipped with a warning are planned again by the next migrations.
+func (p *MigrationPlan) skip(table string) {
+	for i, model := range p.Models {
+		if model.Table == table {
+			p.Models = append(p.Models[:i], p.Models[i+1:]...)
+			return
+		}
+	}
+}
+
+// Destructive returns the destructive statements not approved by the models.
+func (p *MigrationPlan) Destructive() []Statement {
+	var statements []Statement
+	for _, statement := range p.Statements {
+		if statement.Destructive != "" && !statement.Approved {
+			statements = append(statements, statement)
+		}
+	}
+
+	return statements
+}
+
+// String returns the plan as a SQL script.
+func (p *MigrationPlan) String() string {
+	var b strings.Builder
+	for _, statement := range p.Statements {
+		target := statement.Table
+		if statement.Column != "" {
+			target += "." + statement.Column
+		}
+		if statement.Destructive != "" {
+			fmt.Fprintf(&b, "-- %s: %s (destructive: %s)\n%s\n", statement.Reason, target, statement.Destructive, statement.SQL)
+			continue
+		}
+		fmt.Fprintf(&b, "-- %s: %s\n%s\n", statement.Reason, target, statement.SQL)
+	}
+	for _, statement := range p.Orphans {
+		target := statement.Table
+		if statement.Column != "" {
+			target += "." + statement.Column
+		}
+		fmt.Fprintf(&b, "-- %s: %s (not pruned)\n-- %s\n", statement.Reason, target, statement.SQL)
+	}
+
+	return b.String()
+}
+
+// Plan introspects the database and returns the statements MigrateModels
+// would execute for the models, without executing them.
+func (m *Migrator) Plan(models ...interface{}) (*MigrationPlan, error) {
+	return m.PlanContext(context.Background(), models...)
+}
+
+// PlanContext is Plan with a context.
+func (m *Migrator) PlanContext(ctx context.Context, models ...interface{}) (*MigrationPlan, error) {
+	dialect, err := m.dialectContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	checksums, err := m.lastChecksums(ctx, dialect)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]reflect.Type, len(models))
+	byType := make(map[reflect.Type]*Table)
+	for i, model := range models {
+		types[i] = relationType(reflect.TypeOf(model))
+		byType[types[i]] = nil
+	}
+	tables := make([]*Table, len(models))
+	for i, model := range types {
+		tables[i], err = m.parseModel(ctx, dialect, model, byType)
+		if err != nil {
+			return nil, err
+		}
+		byType[model] = tables[i]
+	}
+	relations := modelRelations(types, byType)
+	if !m.IgnoreForeignKeys {
+		m.inferForeignKeys(tables, types, relations)
+	}
+	ordered, cyclic, err := orderTables(tables, relations)
+	if err != nil {
+		return nil, err
+	}
+	current, err := dialect.Inspect(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	plan := MigrationPlan{Driver: NewDBDriver(dialect.Name())}
+	changed := make(map[string]bool)
+	for _, table := range ordered {
+		checksum := modelChecksum(dialect, table)
+		if checksums[table.Name] == checksum {
+			plan.Unchanged = append(plan.Unchanged, table.Name)
+			continue
+		}
+		plan.Models = append(plan.Models, ModelRevision{Table: table.Name, Checksum: checksum})
+		changed[table.Name] = true
+	}
+	var deferred []Change
+	var removed []*Table
+	changes := Diff(&Schema{Tables: ordered}, current)
+	if _, ok := dialect.(TableRebuilder); ok {
+		changes = m.rebuildChanges(changes)
+	}
+	for _, change := range changes {
+		if change.Kind == ChangeDropTable {
+			if m.isPrunable(change.Table.Name) {
+				removed = append(removed, change.Table)
+			}
+			continue
+		}
+		if !changed[change.Table.Name] {
+			var pruned bool
+			if change, pruned = m.prunedChange(change); !pruned {
+				continue
+			}
+		}
+		// foreign keys closing a cycle are added once every table exists
+		if change.ForeignKey != nil && cyclic[change.ForeignKey] {
+			deferred = append(deferred, change)
+			continue
+		}
+		err = m.planChange(ctx, dialect, &plan, change)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, change := range deferred {
+		err = m.planChange(ctx, dialect, &plan, change)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, table := range dropOrder(removed) {
+		err = m.planChange(ctx, dialect, &plan, Change{Kind: ChangeDropTable, Table: table})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &plan, nil
+}
+
+// prunedChange returns the column drops of a change of an unchanged model.
+// Pruned columns are dropped whatever the checksum of the model, which does
+// not change when a previous run only reported them.
+func (m *Migrator) prunedChange(change Change) (Change, bool) {
+	if m.Prune&PruneColumns == 0 {
+		return change, false
+	}
+	switch change.Kind {
+	case ChangeDropColumn:
+		return change, true
+	case ChangeRebuildTable:
+		var drops []Change
+		for _, rebuilt := range change.Rebuilt {
+			if rebuilt.Kind == ChangeDropColumn {
+				drops = append(drops, rebuilt)
+			}
+		}
+		change.Rebuilt = drops
+		return change, len(drops) > 0
+	}
+
+	return change, false
+}
+
+// batch is a group of statements executed in a transaction when the dialect
+// supports transactional DDL, with the revisions recorded once they succeed.
+type batch struct {
+	Statements []Statement
+	Models     []ModelRevision
+}
+
+// batches splits the plan in a single batch, or a batch per model when each
+// model is applied on its own.
+func (m *Migrator) batches(plan *MigrationPlan, perModel bool) []batch {
+	if !perModel {
+		return []batch{{Statements: plan.Statements, Models: plan.Models}}
+	}
+	last := make(map[string]int)
+	var batches []batch
+	for _, statement := range plan.Statements {
+		if len(batches) == 0 || batches[len(batches)-1].Statements[0].Table != statement.Table {
+			batches = append(batches, batch{})
+		}
+		batches[len(batches)-1].Statements = append(batches[len(batches)-1].Statements, statement)
+		last[statement.Table] = len(batches) - 1
+	}
+	var unchanged []ModelRevision
+	for _, revision := range plan.Models {
+		i, ok := last[revision.Table]
+		if !ok {
+			unchanged = append(unchanged, revision)
+			continue
+		}
+		batches[i].Models = append(batches[i].Models, revision)
+	}
+	if len(unchanged) > 0 {
+		batches = append(batches, batch{Models: unchanged})
+	}
+
+	return batches
+}
+
+// checkDestructive applies the destructive policy to the plan.
+func (m *Migrator) checkDestructive(ctx context.Context, plan *MigrationPlan) error {
+	destructive := plan.Destructive()
+	if len(destructive) == 0 {
+		return nil
+	}
+	switch m.DestructivePolicy {
+	case DestructiveDeny:
+		return &DestructiveChangeError{Changes: destructive}
+	case DestructiveWarn:
+		for _, statement := range destructive {
+			m.warning(ctx, "destructive change applied", &MigrationError{
+				Table:     statement.Table,
+				Column:    statement.Column,
+				Statement: statement.SQL,
+				Err:       fmt.Errorf("destructive change: %s", statement.Destructive),
+			})
+		}
+	}
+
+	return nil
+}
+
+// Apply executes the statements of the plan and records the revisions of the
+// models in the history table. The plan must be built for the driver of the
+// migrator. Destructive statements are checked against the destructive policy
+// before anything is executed. When the dialect supports transactional DDL the
+// statements are executed in a transaction rolled back on failure, otherwise
+// a *PartialMigrationError reports the statements already executed.
+func (m *Migrator) Apply(plan *MigrationPlan) error {
+	return m.ApplyContext(context.Background(), plan)
+}
+
+// ApplyContext is Apply with a context, cancelling the context interrupts the
+// statement being executed.
+func (m *Migrator) ApplyContext(ctx context.Context, plan *MigrationPlan) error {
+	dialect, err := m.dialectContext(ctx)
+	if err != nil {
+		return err
+	}
+	if driver := NewDBDriver(dialect.Name()); plan.Driver != driver {
+		return fmt.Errorf("%w: plan built for %s, migrator uses %s", ErrDriverMismatch, plan.Driver, driver)
+	}
+	err = m.checkDestructive(ctx, plan)
+	if err != nil {
+		return err
+	}
+	if m.HistoryTable != "" {
+		_, err = m.DB.ExecContext(ctx, dialect.CreateHistoryTable(m.HistoryTable))
+		if err != nil {
+			return err
+		}
+	}
+	transactional := dialect.TransactionalDDL() && m.Transaction != TxDisabled
+	version := time.Now().UTC().Format(historyVersionLayout)
+	var executed []Statement
+	for _, b := range m.batches(plan, !transactional || m.Transaction == TxPerModel) {
+		if transactional {
+			err = m.applyTx(ctx, dialect, b, version)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		done, err := m.applyBatch(ctx, m.DB, dialect, b, version)
+		executed = append(executed, done...)
+		if err != nil {
+			partial := PartialMigrationError{Executed: executed, Err: err}
+			if len(done) < len(b.Statements) {
+				partial.Failed = b.Statements[len(done)]
+			}
+			return &partial
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) applyTx(ctx context.Context, dialect Dialect, b batch, version string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = m.applyBatch(ctx, tx, dialect, b, version)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applyBatch executes the statements of the batch then records its revisions,
+// it returns the statements executed successfully.
+func (m *Migrator) applyBatch(ctx context.Context, q Queryer, dialect Dialect, b batch, version string) ([]Statement, error) {
+	durations := make(map[string]time.Duration)
+	executed := make(map[string][]string)
+	for i, statement := range b.Statements {
+		if i == 0 || b.Statements[i-1].Table != statement.Table {
+			m.modelStart(ctx, statement.Table)
+		}
+		m.beforeStatement(ctx, statement)
+		start := time.Now()
+		_, err := q.ExecContext(ctx, statement.SQL)
+		duration := time.Since(start)
+		durations[statement.Table] += duration
+		if err != nil {
+			err = &MigrationError{
+				Table:     statement.Table,
+				Column:    statement.Column,
+				Statement: statement.SQL,
+				Err:       err,
+			}
+			m.afterStatement(ctx, statement, duration, err)
+			m.modelDone(ctx, statement.Table, durations[statement.Table], err)
+			return b.Statements[:i], err
+		}
+		m.afterStatement(ctx, statement, duration, nil)
+		executed[statement.Table] = append(executed[statement.Table], statement.SQL)
+		if i == len(b.Statements)-1 || b.Statements[i+1].Table != statement.Table {
+			m.modelDone(ctx, statement.Table, durations[statement.Table], nil)
+		}
+	}
+	if m.HistoryTable == "" {
+		return b.Statements, nil
+	}
+	for _, revision := range b.Models {
+		err := m.recordHistory(ctx, q, dialect, version, revision, durations[revision.Table], executed[revision.Table])
+		if err != nil {
+			return b.Statements, err
+		}
+	}
+
+	return b.Statements, nil
+}
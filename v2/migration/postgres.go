@@ -1,220 +1,490 @@
 package migration
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"reflect"
+	"regexp"
 	"strings"
 )
 
-func (m *Migrator) createPostgresSchema(table string, model reflect.Type) error {
-	// ID must be first property of model structure
-	IDfield := model.Field(0)
-	tableMigration := fmt.Sprintf(
-		"CREATE TABLE IF NOT EXISTS %s\n(\n",
-		table,
-	)
-	tableMigration += "		"
-	tableMigration += toSnakeCase(IDfield.Name) + " "
-	pkType := m.convertType(IDfield.Type.String())
-	tableMigration += m.convertType(IDfield.Type.String()) + " "
-	if strings.Contains(pkType, "UUID") {
-		tableMigration += "UNIQUE NOT NULL DEFAULT uuid_generate_v4()"
-	} else {
-		idValues := parseTag(IDfield.Tag.Get("migration"))
-		for _, constraint := range strings.Split(idValues["constraints"], ",") {
-			if strings.Contains(constraint, "auto_increment") {
-				// For 'auto_increment' replace 'INT' with 'SERIAL' for postgres compatibility
-				tableMigration = strings.Replace(tableMigration, "INT", "SERIAL", -1)
-			} else {
-				tableMigration += constraint + " "
-			}
+func init() {
+	RegisterDialect(DBDriverPostgres.String(), &postgresDialect{})
+}
+
+type postgresDialect struct{}
+
+func (d *postgresDialect) Name() string {
+	return DBDriverPostgres.String()
+}
+
+func (d *postgresDialect) Types() *TypeRegistry {
+	return postgresTypes
+}
+
+// TimeType returns TIMESTAMPTZ for timestamps unless they are declared
+// without time zone, and TIME for times of day unless they are declared with
+// time zone
+func (d *postgresDialect) TimeType(timeType TimeType) string {
+	precision := timePrecision(timeType, 6)
+	switch timeType.Kind {
+	case TimeDate:
+		return "DATE"
+	case TimeOfDay:
+		if timeType.TimeZone == TimeZoneWith {
+			return "TIMETZ" + precision
+		}
+		return "TIME" + precision
+	default:
+		if timeType.TimeZone == TimeZoneWithout {
+			return "TIMESTAMP" + precision
 		}
+		return "TIMESTAMPTZ" + precision
+	}
+}
+
+func (d *postgresDialect) FormatDefault(column *Column) string {
+	value := column.Default
+	if isTextType(column.Type) {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	} else if strings.Contains(value, "uuid") {
+		return "uuid_generate_v4()"
+	}
+
+	return value
+}
+
+func (d *postgresDialect) TransactionalDDL() bool {
+	return true
+}
+
+// columnDefinition returns the full definition of the column used by CREATE
+// TABLE and ADD COLUMN
+func (d *postgresDialect) columnDefinition(column *Column) string {
+	definition := column.Type
+	if column.AutoIncrement {
+		// For 'auto_increment' replace 'INT' with 'SERIAL' for postgres compatibility
+		definition = strings.Replace(definition, "INT", "SERIAL", -1)
+	}
+	if column.NotNull || column.PrimaryKey {
+		definition += " NOT NULL"
 	}
-	tableMigration += "\n);"
-	_, err := m.DB.Exec(tableMigration)
+	if column.HasDefault {
+		definition += " DEFAULT " + column.Default
+	}
+
+	return definition
+}
+
+func (d *postgresDialect) CreateTable(table *Table) string {
+	return createTable(table, d.columnDefinition)
+}
+
+func (d *postgresDialect) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE %s;", table)
+}
+
+func (d *postgresDialect) RenameTable(from, to string) string {
+	return fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", from, to)
+}
+
+func (d *postgresDialect) AddColumn(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD COLUMN %s %s;",
+		table,
+		column.Name,
+		d.columnDefinition(column),
+	)
+}
+
+func (d *postgresDialect) DropColumn(table, column string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP COLUMN %s;",
+		table,
+		column,
+	)
+}
 
-	return err
+func (d *postgresDialect) RenameColumn(table, from, to string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s RENAME COLUMN %s TO %s;",
+		table,
+		from,
+		to,
+	)
 }
 
-func (m *Migrator) generatePostgresColumnMigration(table string, params map[string]string) error {
-	infos, err := m.getPostgresSchemaInformation(table, params["column"])
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
+func (d *postgresDialect) AlterType(table string, column *Column) string {
+	using := column.Using
+	if using == "" {
+		using = fmt.Sprintf("%s::%s", column.Name, column.Type)
 	}
-	if infos != nil && strings.Contains(strings.ToLower(params["type"]), strings.ToLower(convertPostgresSqlType(infos.DataType))) {
-		query := fmt.Sprintf(
-			"ALTER TABLE %s DROP COLUMN %s;",
+
+	return fmt.Sprintf(
+		"ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s;",
+		table,
+		column.Name,
+		column.Type,
+		using,
+	)
+}
+
+func (d *postgresDialect) AddConstraint(table string, column *Column, constraint string) string {
+	if constraint == "unique" {
+		return fmt.Sprintf(
+			"ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s);",
 			table,
-			params["column"],
+			uniqueConstraintName(table, column.Name),
+			column.Name,
 		)
-		_, err = m.DB.Exec(query)
-		if err != nil {
-			return err
-		}
 	}
-	query := fmt.Sprintf(
-		"ALTER TABLE %s ADD COLUMN %s %s;\n",
+
+	return fmt.Sprintf(
+		"ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;",
 		table,
-		params["column"],
-		params["type"],
+		column.Name,
 	)
-	_, err = m.DB.Exec(query)
-	if err != nil {
-		rowExist := strings.Contains(err.Error(), "Duplicate column name")
-		if !rowExist {
-			return err
-		}
+}
+
+func (d *postgresDialect) DropNotNull(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;",
+		table,
+		column.Name,
+	)
+}
+
+func (d *postgresDialect) SetDefault(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;",
+		table,
+		column.Name,
+		column.Default,
+	)
+}
+
+func (d *postgresDialect) DropDefault(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT;",
+		table,
+		column.Name,
+	)
+}
+
+func (d *postgresDialect) DropUnique(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP CONSTRAINT %s;",
+		table,
+		name,
+	)
+}
+
+func (d *postgresDialect) AddCheck(table string, check *Check) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s);",
+		table,
+		check.Name,
+		check.Expression,
+	)
+}
+
+func (d *postgresDialect) DropCheck(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP CONSTRAINT %s;",
+		table,
+		name,
+	)
+}
+
+func (d *postgresDialect) CreateIndex(table string, index *IndexDef) string {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
 	}
-	infos, err = m.getPostgresSchemaInformation(table, params["column"])
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
-	}
-	constraints, hasConstraint := params["constraints"]
-	if hasConstraint {
-		for _, constraint := range strings.Split(constraints, ",") {
-			if !checkConstraint(constraint) {
-				fmt.Printf("[WARN] constraint %s is not valid and was ignored\n", constraint)
-				continue
-			}
-			if infos != nil {
-				if constraint == "not null" && infos.IsNullable {
-					continue
-				}
-			}
-			query = fmt.Sprintf(
-				"ALTER TABLE %s ",
-				table,
-			)
-			switch constraint {
-			case "unique":
-				query += fmt.Sprintf(
-					"ADD CONSTRAINT %s UNIQUE(%s);\n",
-					fmt.Sprintf("unique_%s_%s", table, params["column"]),
-					params["column"],
-				)
-			case "not null":
-				query += fmt.Sprintf("ALTER COLUMN %s SET NOT NULL;\n", params["column"])
-			default:
-				fmt.Printf("unknown constraint : %s\n", constraints)
-				continue
-			}
-			_, err = m.DB.Exec(query)
-			if err != nil {
-				return err
-			}
+
+	return fmt.Sprintf(
+		"CREATE %sINDEX %s ON %s (%s);",
+		unique,
+		index.Name,
+		table,
+		strings.Join(index.Columns, ", "),
+	)
+}
+
+func (d *postgresDialect) RenameIndex(_, from, to string) string {
+	return fmt.Sprintf(
+		"ALTER INDEX %s RENAME TO %s;",
+		from,
+		to,
+	)
+}
+
+func (d *postgresDialect) DropIndex(_, index string) string {
+	return fmt.Sprintf("DROP INDEX %s;", index)
+}
+
+// normalizePostgresType converts information_schema data type to the
+// datatypes of the type registry, the length of time types is their
+// precision
+func normalizePostgresType(dataType string, length sql.NullInt64) string {
+	precision := ""
+	if length.Valid && length.Int64 != 6 {
+		precision = fmt.Sprintf("(%d)", length.Int64)
+	}
+	switch dataType {
+	case "character varying":
+		if length.Valid {
+			return fmt.Sprintf("VARCHAR(%d)", length.Int64)
+		}
+		return "VARCHAR"
+	case "character":
+		if length.Valid {
+			return fmt.Sprintf("CHAR(%d)", length.Int64)
 		}
+		return "CHAR"
+	case "smallint":
+		return "SMALLINT"
+	case "integer":
+		return "INT"
+	case "bigint":
+		return "BIGINT"
+	case "real":
+		return "FLOAT4"
+	case "double precision":
+		return "FLOAT8"
+	case "boolean":
+		return "BOOL"
+	case "time with time zone":
+		return "TIMETZ" + precision
+	case "time without time zone":
+		return "TIME" + precision
+	case "timestamp with time zone":
+		return "TIMESTAMPTZ" + precision
+	case "timestamp without time zone":
+		return "TIMESTAMP" + precision
+	default:
+		return strings.ToUpper(dataType)
 	}
-	infos, err = m.getPostgresSchemaInformation(table, params["column"])
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return err
+}
+
+var postgresDefaultCast = regexp.MustCompile(`::[a-z ]+(\(\d+(,\d+)?\))?(\[\])?$`)
+
+// normalizePostgresDefault removes the type casts added by Postgres to the
+// column default expression
+func normalizePostgresDefault(value, datatype string) string {
+	value = postgresDefaultCast.ReplaceAllString(value, "")
+	if !isTextType(datatype) && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		value = strings.Trim(value, "'")
 	}
-	defaultValue, hasDefaultValue := params["default"]
-	if hasDefaultValue && defaultValue != infos.Default {
-		if strings.Contains(params["type"], "VARCHAR") || strings.Contains(params["type"], "TEXT") {
-			defaultValue = "'" + defaultValue + "'"
-		} else if strings.Contains(defaultValue, "uuid") {
-			defaultValue = "uuid_generate_v4()"
-		}
-		query = fmt.Sprintf(
-			"ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;\n",
-			table,
-			params["column"],
-			defaultValue,
-		)
-		_, err = m.DB.Exec(query)
+
+	return value
+}
+
+// normalizePostgresColumn fills the column from its information_schema
+// description
+func normalizePostgresColumn(column *Column, dataType, nullable string, length sql.NullInt64, defaultValue sql.NullString) {
+	column.Type = normalizePostgresType(dataType, length)
+	column.NotNull = nullable == "NO"
+	column.HasDefault = defaultValue.Valid
+	if column.HasDefault {
+		column.Default = normalizePostgresDefault(defaultValue.String, column.Type)
+		column.AutoIncrement = strings.HasPrefix(column.Default, "nextval(")
+	}
+}
+
+func (d *postgresDialect) TableExists(ctx context.Context, q Queryer, table string) (bool, error) {
+	query := `SELECT table_name FROM information_schema.tables
+				WHERE table_schema = current_schema() AND table_name = $1;`
+	var name string
+	err := q.QueryRowContext(ctx, query, table).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (d *postgresDialect) CreateHistoryTable(table string) string {
+	return fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s
+(
+		version VARCHAR(32) NOT NULL,
+		table_name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		statements TEXT NOT NULL,
+		PRIMARY KEY (version, table_name)
+);`,
+		table,
+	)
+}
+
+func (d *postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (d *postgresDialect) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (d *postgresDialect) AddForeignKey(table string, foreignKey *ForeignKey) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s) ON DELETE %s ON UPDATE %s;",
+		table,
+		foreignKey.Name,
+		foreignKey.Column,
+		foreignKey.RefTable,
+		foreignKey.RefColumn,
+		foreignKey.OnDelete,
+		foreignKey.OnUpdate,
+	)
+}
+
+func (d *postgresDialect) DropForeignKey(table, name string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP CONSTRAINT %s;",
+		table,
+		name,
+	)
+}
+
+// postgresReferentialActions maps pg_constraint action codes to SQL actions
+var postgresReferentialActions = map[string]string{
+	"a": "NO ACTION",
+	"r": "RESTRICT",
+	"c": "CASCADE",
+	"n": "SET NULL",
+	"d": "SET DEFAULT",
+}
+
+const postgresColumnsQuery = `SELECT c.table_name, c.column_name, c.data_type, COALESCE(c.character_maximum_length, c.datetime_precision), c.column_default,
+				c.is_nullable, EXISTS (
+					SELECT 1 FROM information_schema.table_constraints tc
+					JOIN information_schema.key_column_usage kcu
+						ON kcu.constraint_name = tc.constraint_name
+						AND kcu.table_schema = tc.table_schema
+						AND kcu.table_name = tc.table_name
+					WHERE tc.table_schema = c.table_schema AND tc.table_name = c.table_name
+						AND tc.constraint_type IN ('UNIQUE', 'PRIMARY KEY') AND kcu.column_name = c.column_name
+				)
+				FROM information_schema.columns c
+				WHERE c.table_schema = current_schema()
+				ORDER BY c.table_name, c.ordinal_position;`
+
+const postgresIndexesQuery = `SELECT t.relname, i.relname, ix.indisunique, ix.indisprimary, a.attname
+				FROM pg_index ix
+				JOIN pg_class t ON t.oid = ix.indrelid
+				JOIN pg_class i ON i.oid = ix.indexrelid
+				JOIN pg_namespace n ON n.oid = t.relnamespace
+				JOIN LATERAL unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, position) ON true
+				JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+				WHERE n.nspname = current_schema()
+				ORDER BY t.relname, i.relname, k.position;`
+
+func (d *postgresDialect) Inspect(ctx context.Context, q Queryer) (*Schema, error) {
+	return inspectPostgres(ctx, q, postgresColumnsQuery, postgresIndexesQuery, normalizePostgresColumn)
+}
+
+// inspectPostgres reads the schema of Postgres and CockroachDB databases, the
+// columns and indexes queries must return the same columns as the Postgres
+// ones
+func inspectPostgres(ctx context.Context, q Queryer, columnsQuery, indexesQuery string,
+	normalizeColumn func(column *Column, dataType, nullable string, length sql.NullInt64, defaultValue sql.NullString)) (*Schema, error) {
+	r := newSchemaReader(ctx, q)
+	err := r.readTables(`SELECT table_name FROM information_schema.tables
+				WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+				ORDER BY table_name;`)
+	if err != nil {
+		return nil, err
+	}
+	err = r.query(columnsQuery, func(rows *sql.Rows) error {
+		var table, dataType, nullable string
+		var column Column
+		var length sql.NullInt64
+		var defaultValue sql.NullString
+		err := rows.Scan(&table, &column.Name, &dataType, &length, &defaultValue, &nullable, &column.Unique)
 		if err != nil {
 			return err
 		}
+		normalizeColumn(&column, dataType, nullable, length, defaultValue)
+		if t, ok := r.tables[table]; ok {
+			t.Columns = append(t.Columns, &column)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	_, isIndex := params["index"]
-	if isIndex {
-		var indexInfo *PostgresIndexInfo
-		indexInfo, err = m.verifyPostgresIndexExists(table, params["column"])
+	err = r.query(indexesQuery, func(rows *sql.Rows) error {
+		var table, index, column string
+		var unique, primary bool
+		err := rows.Scan(&table, &index, &unique, &primary, &column)
 		if err != nil {
 			return err
 		}
-		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.addIndexColumn(table, index, column, unique, primary)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.query(`SELECT cls.relname, con.conname, att.attname, ref.relname, refatt.attname,
+				con.confdeltype, con.confupdtype
+				FROM pg_constraint con
+				JOIN pg_class cls ON cls.oid = con.conrelid
+				JOIN pg_namespace nsp ON nsp.oid = cls.relnamespace
+				JOIN pg_class ref ON ref.oid = con.confrelid
+				JOIN pg_attribute att ON att.attrelid = con.conrelid AND att.attnum = con.conkey[1]
+				JOIN pg_attribute refatt ON refatt.attrelid = con.confrelid AND refatt.attnum = con.confkey[1]
+				WHERE con.contype = 'f' AND nsp.nspname = current_schema()
+				ORDER BY cls.relname, con.conname;`, func(rows *sql.Rows) error {
+		var table, onDelete, onUpdate string
+		var foreignKey ForeignKey
+		err := rows.Scan(
+			&table,
+			&foreignKey.Name,
+			&foreignKey.Column,
+			&foreignKey.RefTable,
+			&foreignKey.RefColumn,
+			&onDelete,
+			&onUpdate,
+		)
+		if err != nil {
 			return err
 		}
-		if strings.Compare(indexInfo.IndexName, fmt.Sprintf("index_%s", params["column"])) != 0 || errors.Is(err, sql.ErrNoRows) {
-			query = fmt.Sprintf(
-				"CREATE INDEX index_%s ON %s (%s);\n",
-				params["column"],
-				table,
-				params["column"],
-			)
-			_, err = m.DB.Exec(query)
-			if err != nil {
-				return err
-			}
+		foreignKey.OnDelete, _ = normalizeReferentialAction(postgresReferentialActions[onDelete])
+		foreignKey.OnUpdate, _ = normalizeReferentialAction(postgresReferentialActions[onUpdate])
+		if t, ok := r.tables[table]; ok {
+			t.ForeignKeys = append(t.ForeignKeys, &foreignKey)
 		}
-	}
-
-	return nil
-}
-
-type PostgresTableInfo struct {
-	ColumnName string
-	DataType   string
-	IsNullable bool
-	Default    interface{}
-}
-
-func (m *Migrator) getPostgresSchemaInformation(table, column string) (*PostgresTableInfo, error) {
-	query := fmt.Sprintf(
-		`select column_name, data_type, column_default, is_nullable 
-				from INFORMATION_SCHEMA.COLUMNS where table_name = '%s' and column_name = '%s' ;`,
-		table,
-		column,
-	)
-	var nullable string
-	var result PostgresTableInfo
-	err := m.DB.QueryRow(query).Scan(&result.ColumnName, &result.DataType, &result.Default, &nullable)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	result.IsNullable = strings.Contains(nullable, "YES")
-
-	return &result, err
-}
-
-type PostgresIndexInfo struct {
-	TableName  string
-	IndexName  string
-	ColumnName string
-}
-
-func (m *Migrator) verifyPostgresIndexExists(table, column string) (*PostgresIndexInfo, error) {
-	query := fmt.Sprintf(`select
-				t.relname as table_name,
-				i.relname as index_name,
-				a.attname as column_name
-			from
-				pg_class t,
-				pg_class i,
-				pg_index ix,
-				pg_attribute a
-			where
-				t.oid = ix.indrelid
-			  and i.oid = ix.indexrelid
-			  and a.attrelid = t.oid
-			  and a.attnum = ANY(ix.indkey)
-			  and t.relkind = 'r'
-			  and t.relname = '%s'
-			  and a.attname = '%s'
-			order by
-				t.relname,
-				i.relname;`,
-		table,
-		column,
-	)
-	var result PostgresIndexInfo
-	err := m.DB.QueryRow(query).Scan(&result.TableName, &result.IndexName, &result.ColumnName)
+	err = r.query(`SELECT cls.relname, con.conname, pg_get_constraintdef(con.oid)
+				FROM pg_constraint con
+				JOIN pg_class cls ON cls.oid = con.conrelid
+				JOIN pg_namespace nsp ON nsp.oid = cls.relnamespace
+				WHERE con.contype = 'c' AND nsp.nspname = current_schema()
+				ORDER BY cls.relname, con.conname;`, func(rows *sql.Rows) error {
+		var table string
+		var check Check
+		err := rows.Scan(&table, &check.Name, &check.Expression)
+		if err != nil {
+			return err
+		}
+		check.Expression = strings.TrimPrefix(check.Expression, "CHECK ")
+		if t, ok := r.tables[table]; ok {
+			t.Checks = append(t.Checks, &check)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &result, err
+	return r.schema, nil
 }
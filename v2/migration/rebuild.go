@@ -0,0 +1,114 @@
+package migration
+
+import "context"
+
+// TableRebuilder is implemented by the dialects which cannot alter the
+// columns and constraints of a table, like SQLite. Such tables are rebuilt: a
+// table is created with the new definition, the rows are copied and it
+// replaces the previous table.
+type TableRebuilder interface {
+	// RebuildTable returns the statements replacing the table by a table with
+	// its definition, copying the values of the columns, and creating its
+	// indexes again.
+	RebuildTable(table *Table, columns []*Column) []string
+}
+
+// rebuildChanges replaces the changes of a table which cannot be applied by
+// the dialect by a single rebuild, planned after the last change of the
+// table. Renames are applied before the rebuild and the foreign keys of the
+// new tables are declared by their CREATE TABLE statement.
+func (m *Migrator) rebuildChanges(changes []Change) []Change {
+	created := make(map[string]bool)
+	rebuilt := make(map[string]bool)
+	last := make(map[string]int)
+	for i, change := range changes {
+		name := change.Table.Name
+		switch change.Kind {
+		case ChangeAddTable:
+			created[name] = true
+		case ChangeAlterType, ChangeSetNotNull, ChangeDropNotNull, ChangeAddUnique, ChangeDropUnique, ChangeSetDefault,
+			ChangeDropDefault, ChangeRenameIndex, ChangeAddForeignKey, ChangeAlterForeignKey, ChangeDropForeignKey,
+			ChangeAddCheck, ChangeDropCheck:
+			rebuilt[name] = rebuilt[name] || !created[name]
+		case ChangeAddColumn:
+			// ADD COLUMN rejects the NOT NULL columns without default and the
+			// non constant defaults
+			rebuilt[name] = rebuilt[name] || change.Column.NotNull || change.Column.HasDefault
+		case ChangeDropColumn:
+			rebuilt[name] = rebuilt[name] || m.Prune&PruneColumns != 0
+		}
+		last[name] = i
+	}
+	var result []Change
+	rebuilds := make(map[string]*Change)
+	for i, change := range changes {
+		name := change.Table.Name
+		switch {
+		case created[name] && change.Kind == ChangeAddForeignKey:
+			continue
+		case !rebuilt[name] || change.Kind == ChangeRenameTable || change.Kind == ChangeRenameColumn:
+			result = append(result, change)
+		default:
+			rebuild, ok := rebuilds[name]
+			if !ok {
+				rebuild = &Change{Kind: ChangeRebuildTable, Table: change.Table}
+				rebuilds[name] = rebuild
+			}
+			rebuild.Rebuilt = append(rebuild.Rebuilt, change)
+		}
+		if rebuilt[name] && last[name] == i {
+			result = append(result, *rebuilds[name])
+		}
+	}
+
+	return result
+}
+
+// planRebuild adds the statements rebuilding the table, the columns which are
+// not pruned are kept. The first statement is classified with the most
+// destructive of the rebuilt changes.
+func (m *Migrator) planRebuild(ctx context.Context, dialect Dialect, plan *MigrationPlan, change Change) error {
+	table := *change.Table
+	table.Columns = append([]*Column(nil), change.Table.Columns...)
+	added := make(map[string]bool)
+	var destruction Destruction
+	approved := true
+	for _, rebuilt := range change.Rebuilt {
+		if rebuilt.Kind == ChangeAddColumn {
+			added[rebuilt.Column.Name] = true
+		}
+		if rebuilt.Kind == ChangeDropColumn && m.Prune&PruneColumns == 0 {
+			table.Columns = append(table.Columns, rebuilt.CurrentColumn)
+			err := m.planChange(ctx, dialect, plan, rebuilt)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if rebuilt.Kind == ChangeAlterIndex || rebuilt.Kind == ChangeDropIndex {
+			// the indexes are created again by the rebuild
+			continue
+		}
+		d, a, err := m.destruction(ctx, dialect, rebuilt)
+		if err != nil {
+			return err
+		}
+		if d != "" && (destruction == "" || approved && !a) {
+			destruction, approved = d, a
+		}
+	}
+	var columns []*Column
+	for _, column := range table.Columns {
+		if !added[column.Name] {
+			columns = append(columns, column)
+		}
+	}
+	for i, query := range dialect.(TableRebuilder).RebuildTable(&table, columns) {
+		plan.add(table.Name, "", ReasonRebuiltTable, query, "")
+		if i == 0 && destruction != "" {
+			plan.classify(destruction, approved)
+		}
+	}
+
+	return nil
+}
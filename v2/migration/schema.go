@@ -0,0 +1,119 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Schema describes the tables of a database, either declared by the models or
+// read from the database by Inspect.
+type Schema struct {
+	Tables []*Table
+}
+
+// Check describes a check constraint of a table.
+type Check struct {
+	Name       string
+	Expression string
+}
+
+// Table returns the table of the schema, nil if it does not exist.
+func (s *Schema) Table(name string) *Table {
+	for _, table := range s.Tables {
+		if table.Name == name {
+			return table
+		}
+	}
+
+	return nil
+}
+
+// Column returns the column of the table, nil if it does not exist.
+func (t *Table) Column(name string) *Column {
+	for _, column := range t.Columns {
+		if column.Name == name {
+			return column
+		}
+	}
+
+	return nil
+}
+
+// Inspect reads the tables of the database with their columns, indexes,
+// foreign keys and check constraints.
+func (m *Migrator) Inspect(ctx context.Context) (*Schema, error) {
+	dialect, err := m.dialectContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialect.Inspect(ctx, m.DB)
+}
+
+// schemaReader assembles the schema read by the bulk introspection queries
+// of the dialects, rows of unknown tables (views) are ignored.
+type schemaReader struct {
+	ctx    context.Context
+	q      Queryer
+	schema *Schema
+	tables map[string]*Table
+}
+
+func newSchemaReader(ctx context.Context, q Queryer) *schemaReader {
+	return &schemaReader{
+		ctx:    ctx,
+		q:      q,
+		schema: &Schema{},
+		tables: make(map[string]*Table),
+	}
+}
+
+// query runs the query and calls scan for every row
+func (r *schemaReader) query(query string, scan func(rows *sql.Rows) error) error {
+	rows, err := r.q.QueryContext(r.ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// readTables reads the table names returned by the query
+func (r *schemaReader) readTables(query string) error {
+	return r.query(query, func(rows *sql.Rows) error {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		table := &Table{Name: name}
+		r.schema.Tables = append(r.schema.Tables, table)
+		r.tables[name] = table
+		return nil
+	})
+}
+
+// addIndexColumn adds the column to the index of the table, rows must be
+// ordered by table, index and position in the index.
+func (r *schemaReader) addIndexColumn(table, index, column string, unique, primary bool) {
+	t, ok := r.tables[table]
+	if !ok {
+		return
+	}
+	if primary {
+		t.PrimaryKey = append(t.PrimaryKey, column)
+		return
+	}
+	if len(t.Indexes) == 0 || t.Indexes[len(t.Indexes)-1].Name != index {
+		t.Indexes = append(t.Indexes, &IndexDef{Name: index, Unique: unique})
+	}
+	last := t.Indexes[len(t.Indexes)-1]
+	last.Columns = append(last.Columns, column)
+}
@@ -0,0 +1,397 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterDialect(DBDriverSQLite.String(), &sqliteDialect{})
+}
+
+// sqliteDialect cannot alter columns and constraints, the tables are rebuilt
+// instead. Foreign keys must not be enforced while migrating, dropping the
+// rebuilt table would cascade to the referencing rows: keep the SQLite
+// default of foreign_keys=OFF on the migration connection.
+type sqliteDialect struct{}
+
+func (d *sqliteDialect) Name() string {
+	return DBDriverSQLite.String()
+}
+
+func (d *sqliteDialect) Types() *TypeRegistry {
+	return sqliteTypes
+}
+
+// TimeType returns DATE, TIME or DATETIME, SQLite stores times as text
+// without time zone nor precision
+func (d *sqliteDialect) TimeType(timeType TimeType) string {
+	switch timeType.Kind {
+	case TimeDate:
+		return "DATE"
+	case TimeOfDay:
+		return "TIME"
+	default:
+		return "DATETIME"
+	}
+}
+
+func (d *sqliteDialect) FormatDefault(column *Column) string {
+	value := column.Default
+	if isTextType(column.Type) {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	} else if strings.Contains(value, "uuid") {
+		return "(lower(hex(randomblob(16))))"
+	} else if strings.EqualFold(value, "now()") {
+		return "CURRENT_TIMESTAMP"
+	}
+
+	return value
+}
+
+func (d *sqliteDialect) TransactionalDDL() bool {
+	return true
+}
+
+func (d *sqliteDialect) columnDefinition(column *Column) string {
+	definition := column.Type
+	if column.NotNull || column.PrimaryKey {
+		definition += " NOT NULL"
+	}
+	if column.HasDefault {
+		definition += " DEFAULT " + column.Default
+	}
+
+	return definition
+}
+
+// CreateTable declares the foreign keys in the CREATE TABLE statement, they
+// cannot be added later
+func (d *sqliteDialect) CreateTable(table *Table) string {
+	var constraints []string
+	for _, foreignKey := range table.ForeignKeys {
+		constraints = append(constraints, fmt.Sprintf(
+			"CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s) ON DELETE %s ON UPDATE %s",
+			foreignKey.Name,
+			foreignKey.Column,
+			foreignKey.RefTable,
+			foreignKey.RefColumn,
+			foreignKey.OnDelete,
+			foreignKey.OnUpdate,
+		))
+	}
+
+	return createTable(table, d.columnDefinition, constraints...)
+}
+
+func (d *sqliteDialect) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE %s;", table)
+}
+
+func (d *sqliteDialect) RenameTable(from, to string) string {
+	return fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", from, to)
+}
+
+// RebuildTable creates the table under a temporary name, copies the rows,
+// drops the previous table and renames the new one. The Using expressions
+// convert the copied values.
+func (d *sqliteDialect) RebuildTable(table *Table, columns []*Column) []string {
+	rebuild := table.Name + "_rebuild"
+	create := strings.Replace(
+		d.CreateTable(table),
+		"CREATE TABLE IF NOT EXISTS "+table.Name+"\n",
+		"CREATE TABLE "+rebuild+"\n",
+		1,
+	)
+	names := make([]string, len(columns))
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+		values[i] = column.Name
+		if column.Using != "" {
+			values[i] = column.Using
+		}
+	}
+	statements := []string{
+		create,
+		fmt.Sprintf(
+			"INSERT INTO %s (%s) SELECT %s FROM %s;",
+			rebuild,
+			strings.Join(names, ", "),
+			strings.Join(values, ", "),
+			table.Name,
+		),
+		d.DropTable(table.Name),
+		d.RenameTable(rebuild, table.Name),
+	}
+	for _, index := range table.Indexes {
+		statements = append(statements, d.CreateIndex(table.Name, index))
+	}
+
+	return statements
+}
+
+func (d *sqliteDialect) AddColumn(table string, column *Column) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD COLUMN %s %s;",
+		table,
+		column.Name,
+		d.columnDefinition(column),
+	)
+}
+
+func (d *sqliteDialect) DropColumn(table, column string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s DROP COLUMN %s;",
+		table,
+		column,
+	)
+}
+
+func (d *sqliteDialect) RenameColumn(table, from, to string) string {
+	return fmt.Sprintf(
+		"ALTER TABLE %s RENAME COLUMN %s TO %s;",
+		table,
+		from,
+		to,
+	)
+}
+
+// AlterType is not supported, the table is rebuilt
+func (d *sqliteDialect) AlterType(_ string, _ *Column) string {
+	return ""
+}
+
+// AddConstraint is not supported, the table is rebuilt
+func (d *sqliteDialect) AddConstraint(_ string, _ *Column, _ string) string {
+	return ""
+}
+
+// DropNotNull is not supported, the table is rebuilt
+func (d *sqliteDialect) DropNotNull(_ string, _ *Column) string {
+	return ""
+}
+
+// SetDefault is not supported, the table is rebuilt
+func (d *sqliteDialect) SetDefault(_ string, _ *Column) string {
+	return ""
+}
+
+// DropDefault is not supported, the table is rebuilt
+func (d *sqliteDialect) DropDefault(_ string, _ *Column) string {
+	return ""
+}
+
+// DropUnique is not supported, the table is rebuilt
+func (d *sqliteDialect) DropUnique(_, _ string) string {
+	return ""
+}
+
+// AddCheck is not supported, the table is rebuilt
+func (d *sqliteDialect) AddCheck(_ string, _ *Check) string {
+	return ""
+}
+
+// DropCheck is not supported, the table is rebuilt
+func (d *sqliteDialect) DropCheck(_, _ string) string {
+	return ""
+}
+
+func (d *sqliteDialect) CreateIndex(table string, index *IndexDef) string {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+
+	return fmt.Sprintf(
+		"CREATE %sINDEX %s ON %s (%s);",
+		unique,
+		index.Name,
+		table,
+		strings.Join(index.Columns, ", "),
+	)
+}
+
+// RenameIndex is not supported, the table is rebuilt
+func (d *sqliteDialect) RenameIndex(_, _, _ string) string {
+	return ""
+}
+
+func (d *sqliteDialect) DropIndex(_, index string) string {
+	return fmt.Sprintf("DROP INDEX %s;", index)
+}
+
+func (d *sqliteDialect) TableExists(ctx context.Context, q Queryer, table string) (bool, error) {
+	query := `SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?;`
+	var name string
+	err := q.QueryRowContext(ctx, query, table).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (d *sqliteDialect) CreateHistoryTable(table string) string {
+	return fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s
+(
+		version VARCHAR(32) NOT NULL,
+		table_name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at DATETIME NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		statements TEXT NOT NULL,
+		PRIMARY KEY (version, table_name)
+);`,
+		table,
+	)
+}
+
+func (d *sqliteDialect) Placeholder(_ int) string {
+	return "?"
+}
+
+func (d *sqliteDialect) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// AddForeignKey is not supported, the table is rebuilt
+func (d *sqliteDialect) AddForeignKey(_ string, _ *ForeignKey) string {
+	return ""
+}
+
+// DropForeignKey is not supported, the table is rebuilt
+func (d *sqliteDialect) DropForeignKey(_, _ string) string {
+	return ""
+}
+
+// Inspect reads the schema with the pragma table-valued functions. SQLite
+// does not name foreign keys and unique constraints, they are named like the
+// ones created by the migrator, and check constraints are not read.
+func (d *sqliteDialect) Inspect(ctx context.Context, q Queryer) (*Schema, error) {
+	r := newSchemaReader(ctx, q)
+	err := r.readTables(`SELECT name FROM sqlite_master
+				WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+				ORDER BY name;`)
+	if err != nil {
+		return nil, err
+	}
+	// position of the columns in the primary keys
+	positions := make(map[*Table]map[string]int)
+	err = r.query(`SELECT m.name, p.name, p.type, p."notnull", p.dflt_value, p.pk
+				FROM sqlite_master m
+				JOIN pragma_table_info(m.name) p
+				WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+				ORDER BY m.name, p.cid;`, func(rows *sql.Rows) error {
+		var table string
+		var column Column
+		var defaultValue sql.NullString
+		var position int
+		err := rows.Scan(&table, &column.Name, &column.Type, &column.NotNull, &defaultValue, &position)
+		if err != nil {
+			return err
+		}
+		column.PrimaryKey = position > 0
+		column.Unique = column.PrimaryKey
+		column.HasDefault = defaultValue.Valid
+		column.Default = defaultValue.String
+		t, ok := r.tables[table]
+		if !ok {
+			return nil
+		}
+		t.Columns = append(t.Columns, &column)
+		if column.PrimaryKey {
+			if positions[t] == nil {
+				positions[t] = make(map[string]int)
+			}
+			positions[t][column.Name] = position
+			t.PrimaryKey = append(t.PrimaryKey, column.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for t, position := range positions {
+		sort.Slice(t.PrimaryKey, func(i, j int) bool {
+			return position[t.PrimaryKey[i]] < position[t.PrimaryKey[j]]
+		})
+	}
+	// indexes of the unique constraints, SQLite names them sqlite_autoindex_*
+	constraints := make(map[string]bool)
+	err = r.query(`SELECT m.name, il.name, il."unique", il.origin, ii.name
+				FROM sqlite_master m
+				JOIN pragma_index_list(m.name) il
+				JOIN pragma_index_info(il.name) ii
+				WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+				ORDER BY m.name, il.name, ii.seqno;`, func(rows *sql.Rows) error {
+		var table, index, origin, column string
+		var unique bool
+		err := rows.Scan(&table, &index, &unique, &origin, &column)
+		if err != nil {
+			return err
+		}
+		// the primary key is read from table_info
+		if origin != "pk" {
+			r.addIndexColumn(table, index, column, unique, false)
+		}
+		constraints[index] = origin == "u"
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// single column unique indexes make their column unique, the unique
+	// constraints are named like the ones created by the migrator
+	for _, table := range r.schema.Tables {
+		for _, index := range table.Indexes {
+			if !index.Unique || len(index.Columns) != 1 {
+				continue
+			}
+			if column := table.Column(index.Columns[0]); column != nil {
+				column.Unique = true
+			}
+			if constraints[index.Name] {
+				index.Name = uniqueConstraintName(table.Name, index.Columns[0])
+			}
+		}
+	}
+	err = r.query(`SELECT m.name, fk."from", fk."table", fk."to", fk.on_delete, fk.on_update
+				FROM sqlite_master m
+				JOIN pragma_foreign_key_list(m.name) fk
+				WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+				ORDER BY m.name, fk.id, fk.seq;`, func(rows *sql.Rows) error {
+		var table string
+		var foreignKey ForeignKey
+		var refColumn sql.NullString
+		err := rows.Scan(
+			&table,
+			&foreignKey.Column,
+			&foreignKey.RefTable,
+			&refColumn,
+			&foreignKey.OnDelete,
+			&foreignKey.OnUpdate,
+		)
+		if err != nil {
+			return err
+		}
+		foreignKey.Name = foreignKeyName(table, foreignKey.Column)
+		foreignKey.RefColumn = refColumn.String
+		foreignKey.OnDelete, _ = normalizeReferentialAction(foreignKey.OnDelete)
+		foreignKey.OnUpdate, _ = normalizeReferentialAction(foreignKey.OnUpdate)
+		if t, ok := r.tables[table]; ok {
+			t.ForeignKeys = append(t.ForeignKeys, &foreignKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.schema, nil
+}
@@ -0,0 +1,252 @@
+package migration
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SQLType is the SQL datatype of a go type, a %d verb is replaced by the
+// default text size of the migrator.
+type SQLType string
+
+// TypeRegistry maps go types to the SQL datatypes of a dialect. Types missing
+// from the registry are looked up in the registry it extends, named types
+// like 'type Role string' fall back to their underlying basic type.
+type TypeRegistry struct {
+	mu     sync.RWMutex
+	types  map[reflect.Type]SQLType
+	parent *TypeRegistry
+}
+
+// NewTypeRegistry returns a registry extending parent, which can be nil.
+func NewTypeRegistry(parent *TypeRegistry) *TypeRegistry {
+	return &TypeRegistry{types: make(map[reflect.Type]SQLType), parent: parent}
+}
+
+// RegisterType maps the go type to the SQL datatype, replacing the previous
+// mapping of the type.
+func (r *TypeRegistry) RegisterType(t reflect.Type, sqlType SQLType) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[t] = sqlType
+}
+
+func (r *TypeRegistry) lookup(t reflect.Type) (SQLType, bool) {
+	r.mu.RLock()
+	sqlType, ok := r.types[t]
+	r.mu.RUnlock()
+	if !ok && r.parent != nil {
+		return r.parent.lookup(t)
+	}
+
+	return sqlType, ok
+}
+
+// typeOf returns the reflect.Type of T
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// basicTypes are the unnamed types of the kinds of named types
+var basicTypes = map[reflect.Kind]reflect.Type{
+	reflect.Bool:    typeOf[bool](),
+	reflect.Int:     typeOf[int](),
+	reflect.Int8:    typeOf[int8](),
+	reflect.Int16:   typeOf[int16](),
+	reflect.Int32:   typeOf[int32](),
+	reflect.Int64:   typeOf[int64](),
+	reflect.Uint:    typeOf[uint](),
+	reflect.Uint8:   typeOf[uint8](),
+	reflect.Uint16:  typeOf[uint16](),
+	reflect.Uint32:  typeOf[uint32](),
+	reflect.Uint64:  typeOf[uint64](),
+	reflect.Float32: typeOf[float32](),
+	reflect.Float64: typeOf[float64](),
+	reflect.String:  typeOf[string](),
+}
+
+// SQLType returns the SQL datatype of the go type, nullable types have the
+// datatype of their value unless they are registered. It returns an
+// ErrUnsupportedType error for the types without datatype.
+func (r *TypeRegistry) SQLType(t reflect.Type, textSize uint8) (string, error) {
+	sqlType, ok := r.lookup(t)
+	for nullable := true; !ok && nullable; {
+		t, nullable = nullableType(t)
+		sqlType, ok = r.lookup(t)
+	}
+	if basic, isBasic := basicTypes[t.Kind()]; !ok && isBasic {
+		sqlType, ok = r.lookup(basic)
+	}
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, t)
+	}
+	if strings.Contains(string(sqlType), "%d") {
+		return fmt.Sprintf(string(sqlType), textSize), nil
+	}
+
+	return string(sqlType), nil
+}
+
+// isUUID reports whether the go type has the datatype of the UUIDs, like the
+// UUID types of other packages registered with it
+func (r *TypeRegistry) isUUID(t reflect.Type) bool {
+	uuidType, err := r.SQLType(typeOf[uuid.UUID](), 0)
+	if err != nil {
+		return false
+	}
+	sqlType, err := r.SQLType(t, 0)
+
+	return err == nil && strings.EqualFold(sqlType, uuidType)
+}
+
+// nullTypes maps the sql.Null types to the types of their value
+var nullTypes = map[reflect.Type]reflect.Type{
+	typeOf[sql.NullBool]():    typeOf[bool](),
+	typeOf[sql.NullByte]():    typeOf[byte](),
+	typeOf[sql.NullInt16]():   typeOf[int16](),
+	typeOf[sql.NullInt32]():   typeOf[int32](),
+	typeOf[sql.NullInt64]():   typeOf[int64](),
+	typeOf[sql.NullFloat64](): typeOf[float64](),
+	typeOf[sql.NullString]():  typeOf[string](),
+	typeOf[sql.NullTime]():    typeOf[time.Time](),
+}
+
+// nullableType returns the type of the values of the nullable types: the
+// element of pointers and the value of the sql.Null types. It reports whether
+// the type is nullable.
+func nullableType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem(), true
+	}
+	if value, ok := nullTypes[t]; ok {
+		return value, true
+	}
+	// the generic sql.Null[T] type
+	if t.Kind() == reflect.Struct && t.PkgPath() == "database/sql" && strings.HasPrefix(t.Name(), "Null[") {
+		if field, ok := t.FieldByName("V"); ok {
+			return field.Type, true
+		}
+	}
+
+	return t, false
+}
+
+// newDialectTypes returns a registry of the types
+func newDialectTypes(parent *TypeRegistry, types map[reflect.Type]SQLType) *TypeRegistry {
+	registry := NewTypeRegistry(parent)
+	for t, sqlType := range types {
+		registry.RegisterType(t, sqlType)
+	}
+
+	return registry
+}
+
+var (
+	postgresTypes = newDialectTypes(nil, map[reflect.Type]SQLType{
+		typeOf[bool]():          "BOOL",
+		typeOf[int]():           "INT",
+		typeOf[int8]():          "SMALLINT",
+		typeOf[int16]():         "SMALLINT",
+		typeOf[int32]():         "INT",
+		typeOf[int64]():         "BIGINT",
+		typeOf[uint]():          "NUMERIC",
+		typeOf[uint8]():         "SMALLINT",
+		typeOf[uint16]():        "INT",
+		typeOf[uint32]():        "BIGINT",
+		typeOf[uint64]():        "NUMERIC",
+		typeOf[float32]():       "FLOAT4",
+		typeOf[float64]():       "FLOAT8",
+		typeOf[string]():        "VARCHAR(%d)",
+		typeOf[[]byte]():        "BYTEA",
+		typeOf[time.Duration](): "BIGINT",
+		typeOf[time.Time]():     "TIMESTAMPTZ",
+		typeOf[uuid.UUID]():     "UUID",
+	})
+	// INT is an alias of INT8 in CockroachDB
+	cockroachTypes = newDialectTypes(postgresTypes, map[reflect.Type]SQLType{
+		typeOf[int]():           "INT8",
+		typeOf[int8]():          "INT2",
+		typeOf[int16]():         "INT2",
+		typeOf[int32]():         "INT4",
+		typeOf[int64]():         "INT8",
+		typeOf[uint8]():         "INT2",
+		typeOf[uint16]():        "INT4",
+		typeOf[uint32]():        "INT8",
+		typeOf[time.Duration](): "INT8",
+	})
+	mysqlTypes = newDialectTypes(nil, map[reflect.Type]SQLType{
+		typeOf[bool]():          "BOOL",
+		typeOf[int]():           "INT",
+		typeOf[int8]():          "TINYINT",
+		typeOf[int16]():         "SMALLINT",
+		typeOf[int32]():         "INT",
+		typeOf[int64]():         "BIGINT",
+		typeOf[uint]():          "BIGINT UNSIGNED",
+		typeOf[uint8]():         "TINYINT UNSIGNED",
+		typeOf[uint16]():        "SMALLINT UNSIGNED",
+		typeOf[uint32]():        "INT UNSIGNED",
+		typeOf[uint64]():        "BIGINT UNSIGNED",
+		typeOf[float32]():       "FLOAT",
+		typeOf[float64]():       "DOUBLE",
+		typeOf[string]():        "VARCHAR(%d)",
+		typeOf[[]byte]():        "BLOB",
+		typeOf[time.Duration](): "BIGINT",
+		typeOf[time.Time]():     "DATETIME(6)",
+		typeOf[uuid.UUID]():     "binary(16)",
+	})
+	// MariaDB has a native uuid type since 10.7
+	mariadbTypes = newDialectTypes(mysqlTypes, map[reflect.Type]SQLType{
+		typeOf[uuid.UUID](): "uuid",
+	})
+	mariadbLegacyTypes = newDialectTypes(mariadbTypes, map[reflect.Type]SQLType{
+		typeOf[uuid.UUID](): "binary(16)",
+	})
+	// INTEGER primary keys are aliases of the rowid
+	sqliteTypes = newDialectTypes(nil, map[reflect.Type]SQLType{
+		typeOf[bool]():          "BOOLEAN",
+		typeOf[int]():           "INTEGER",
+		typeOf[int8]():          "INTEGER",
+		typeOf[int16]():         "INTEGER",
+		typeOf[int32]():         "INTEGER",
+		typeOf[int64]():         "INTEGER",
+		typeOf[uint]():          "INTEGER",
+		typeOf[uint8]():         "INTEGER",
+		typeOf[uint16]():        "INTEGER",
+		typeOf[uint32]():        "INTEGER",
+		typeOf[uint64]():        "INTEGER",
+		typeOf[float32]():       "REAL",
+		typeOf[float64]():       "REAL",
+		typeOf[string]():        "VARCHAR(%d)",
+		typeOf[[]byte]():        "BLOB",
+		typeOf[time.Duration](): "INTEGER",
+		typeOf[time.Time]():     "DATETIME",
+		typeOf[uuid.UUID]():     "TEXT",
+	})
+	// TINYINT is unsigned in SQL Server
+	mssqlTypes = newDialectTypes(nil, map[reflect.Type]SQLType{
+		typeOf[bool]():          "BIT",
+		typeOf[int]():           "INT",
+		typeOf[int8]():          "SMALLINT",
+		typeOf[int16]():         "SMALLINT",
+		typeOf[int32]():         "INT",
+		typeOf[int64]():         "BIGINT",
+		typeOf[uint]():          "DECIMAL(20,0)",
+		typeOf[uint8]():         "TINYINT",
+		typeOf[uint16]():        "INT",
+		typeOf[uint32]():        "BIGINT",
+		typeOf[uint64]():        "DECIMAL(20,0)",
+		typeOf[float32]():       "REAL",
+		typeOf[float64]():       "FLOAT",
+		typeOf[string]():        "NVARCHAR(%d)",
+		typeOf[[]byte]():        "VARBINARY(MAX)",
+		typeOf[time.Duration](): "BIGINT",
+		typeOf[time.Time]():     "DATETIME2",
+		typeOf[uuid.UUID]():     "UNIQUEIDENTIFIER",
+	})
+)
@@ -0,0 +1,126 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TimeKind is the kind of values stored by a time column.
+type TimeKind string
+
+const (
+	// TimeDate stores the date without time of day.
+	TimeDate TimeKind = "date"
+	// TimeOfDay stores the time of day without date.
+	TimeOfDay TimeKind = "time"
+	// TimeTimestamp stores the date and the time of day.
+	TimeTimestamp TimeKind = "timestamp"
+)
+
+// TimeZone selects whether a time column stores its time zone.
+type TimeZone int
+
+const (
+	// TimeZoneDefault uses the default of the dialect: Postgres stores
+	// timestamps with their time zone, MySQL as DATETIME.
+	TimeZoneDefault TimeZone = iota
+	// TimeZoneWith stores the values with their time zone, or converted to
+	// UTC by the dialects without time zone types.
+	TimeZoneWith
+	// TimeZoneWithout stores the values as they are.
+	TimeZoneWithout
+)
+
+// TimeType is the datatype of a time column declared by the time and
+// time_zone tags. Precision is the number of fractional digits of the
+// seconds, -1 for the default precision of the dialect.
+type TimeType struct {
+	Kind      TimeKind
+	Precision int
+	TimeZone  TimeZone
+}
+
+var timeTag = regexp.MustCompile(`^(date|time|timestamp)(?:\((\d)\))?$`)
+
+// parseTimeType returns the time type declared by the time and time_zone tag
+// values, like 'timestamp(3)' and 'false'
+func parseTimeType(kind, timeZone string, hasTimeZone bool) (TimeType, error) {
+	timeType := TimeType{Kind: TimeTimestamp, Precision: -1}
+	if kind != "" {
+		match := timeTag.FindStringSubmatch(strings.ToLower(kind))
+		if match == nil {
+			return timeType, fmt.Errorf("%w: time %s, expected date, time or timestamp with an optional precision", ErrInvalidTag, kind)
+		}
+		timeType.Kind = TimeKind(match[1])
+		if match[2] != "" {
+			timeType.Precision, _ = strconv.Atoi(match[2])
+		}
+	}
+	if hasTimeZone {
+		withTimeZone := true
+		if timeZone != "" {
+			var err error
+			withTimeZone, err = strconv.ParseBool(timeZone)
+			if err != nil {
+				return timeType, fmt.Errorf("%w: time_zone %s, expected true or false", ErrInvalidTag, timeZone)
+			}
+		}
+		timeType.TimeZone = TimeZoneWithout
+		if withTimeZone {
+			timeType.TimeZone = TimeZoneWith
+		}
+	}
+
+	return timeType, nil
+}
+
+// timePrecision returns the precision suffix of a time datatype, the default
+// precision of the dialect is omitted since introspection omits it
+func timePrecision(timeType TimeType, defaultPrecision int) string {
+	if timeType.Precision < 0 || timeType.Precision == defaultPrecision {
+		return ""
+	}
+
+	return fmt.Sprintf("(%d)", timeType.Precision)
+}
+
+// isLegacyTimeColumn reports whether the change converts a TIMETZ column,
+// created for the time.Time fields by previous versions, to a timestamp
+func isLegacyTimeColumn(change Change) bool {
+	if change.Kind != ChangeAlterType || change.CurrentColumn == nil {
+		return false
+	}
+	family, _ := sqlTypeFamily(change.Column.Type)
+
+	return strings.HasPrefix(strings.ToUpper(change.CurrentColumn.Type), "TIMETZ") && family == "timestamp"
+}
+
+// convertLegacyTime returns the column converting a legacy TIMETZ column to
+// a timestamp, the values get the 1970-01-01 date unless the using tag is
+// set. The conversion is lossy: without the allow_lossy tag or
+// WithLossyTypeChanges(true) the column is kept and nil is returned, the
+// revision of the model is then removed from the plan.
+func (m *Migrator) convertLegacyTime(ctx context.Context, change Change) (*Column, error) {
+	column, current := change.Column, change.CurrentColumn
+	if !column.AllowLossy && !m.AllowLossy {
+		return nil, m.warn(ctx, &MigrationError{
+			Table:  change.Table.Name,
+			Column: column.Name,
+			Err: fmt.Errorf(
+				"%w: %s column kept, set the allow_lossy tag or WithLossyTypeChanges(true) to convert it to %s, or the time:time;time_zone tags to keep it",
+				ErrLegacyTimeColumn,
+				current.Type,
+				column.Type,
+			),
+		})
+	}
+	converted := *column
+	if converted.Using == "" {
+		converted.Using = fmt.Sprintf("DATE '1970-01-01' + %s", column.Name)
+	}
+
+	return &converted, nil
+}
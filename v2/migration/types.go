@@ -1,99 +1,317 @@
 package migration
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-// convertType convert go type to SQL datatype
-func (m *Migrator) convertType(kind string) string {
-	isTime, err := regexp.MatchString("Time$", kind)
+// Table describes a table, either declared by a model or read from the
+// database.
+type Table struct {
+	Name string
+	// RenamedFrom is the previous name of the table
+	RenamedFrom string
+	Columns     []*Column
+	PrimaryKey  []string
+	Indexes     []*IndexDef
+	ForeignKeys []*ForeignKey
+	Checks      []*Check
+}
+
+// ForeignKey describes a foreign key constraint of a column.
+type ForeignKey struct {
+	Name      string
+	Column    string
+	RefTable  string
+	RefColumn string
+	OnDelete  string
+	OnUpdate  string
+}
+
+// Column describes a table column, either declared by a model field or read
+// from the database.
+type Column struct {
+	Name          string
+	Type          string
+	NotNull       bool
+	Unique        bool
+	PrimaryKey    bool
+	AutoIncrement bool
+	HasDefault    bool
+	Default       string
+	// Using is the expression converting the column values on type changes
+	Using string
+	// AllowLossy allows type changes which may lose data
+	AllowLossy bool
+	// RenamedFrom is the previous name of the column
+	RenamedFrom string
+}
+
+// parseColumn builds the column declared by the model field of the table
+func (m *Migrator) parseColumn(ctx context.Context, dialect Dialect, table string, field reflect.StructField, values map[string]string, quoted map[string]bool) (*Column, error) {
+	column := Column{
+		Name: toSnakeCase(field.Name),
+	}
+	_, hasType := values["type"]
+	timeKind, hasTime := values["time"]
+	timeZone, hasTimeZone := values["time_zone"]
+	if hasType {
+		column.Type = values["type"]
+	} else if hasTime || hasTimeZone {
+		timeType, err := parseTimeType(timeKind, timeZone, hasTimeZone)
+		if err != nil {
+			return nil, &MigrationError{Table: table, Column: column.Name, Err: err}
+		}
+		column.Type = dialect.TimeType(timeType)
+	} else {
+		sqlType, err := dialect.Types().SQLType(field.Type, m.DefaultTextSize)
+		if err != nil {
+			return nil, &MigrationError{Table: table, Column: column.Name, Err: err}
+		}
+		column.Type = sqlType
+	}
+	if column.Type == "" {
+		return nil, &MigrationError{
+			Table:  table,
+			Column: column.Name,
+			Err:    fmt.Errorf("%w: %s", ErrUnsupportedType, field.Type),
+		}
+	}
+	// fields which cannot hold NULL values are NOT NULL
+	_, nullable := nullableType(field.Type)
+	column.NotNull = !nullable && !m.NullableByDefault
+	constraints, hasConstraint := values["constraints"]
+	if hasConstraint {
+		for _, constraint := range strings.Split(constraints, ",") {
+			constraint = strings.TrimSpace(constraint)
+			switch constraint {
+			case "primary key":
+				column.PrimaryKey = true
+			case "auto_increment":
+				column.AutoIncrement = true
+			case "not null":
+				column.NotNull = true
+			case "null":
+				column.NotNull = false
+			case "unique":
+				column.Unique = true
+			default:
+				err := m.warn(ctx, &MigrationError{
+					Table:  table,
+					Column: column.Name,
+					Err:    fmt.Errorf("%w: %s", ErrInvalidConstraint, constraint),
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	column.Using = values["using"]
+	column.RenamedFrom = values["renamed_from"]
+	_, column.AllowLossy = values["allow_lossy"]
+	column.Default, column.HasDefault = values["default"]
+	if column.PrimaryKey && !column.HasDefault && dialect.Types().isUUID(field.Type) {
+		// UUID primary keys are generated by the database
+		column.Default, column.HasDefault = "uuid", true
+	}
+	if column.HasDefault && quoted["default"] && !isTextType(column.Type) {
+		// quoted defaults are string literals whatever the column type
+		column.Default = "'" + strings.ReplaceAll(column.Default, "'", "''") + "'"
+	} else if column.HasDefault {
+		column.Default = dialect.FormatDefault(&column)
+	}
+
+	return &column, nil
+}
+
+var referencesPattern = regexp.MustCompile(`^\s*(\w+)\s*\(\s*(\w+)\s*\)\s*$`)
+
+// parseForeignKey builds the foreign key declared by the references tag, it
+// returns nil if the column has no valid references tag
+func (m *Migrator) parseForeignKey(ctx context.Context, table, column string, values map[string]string) (*ForeignKey, error) {
+	references, hasReferences := values["references"]
+	if !hasReferences {
+		return nil, nil
+	}
+	match := referencesPattern.FindStringSubmatch(references)
+	if match == nil {
+		return nil, m.warn(ctx, &MigrationError{
+			Table:  table,
+			Column: column,
+			Err:    fmt.Errorf("%w: references %s, expected table(column)", ErrInvalidConstraint, references),
+		})
+	}
+	onDelete, err := m.referentialAction(ctx, table, column, values["on_delete"])
 	if err != nil {
-		return ""
+		return nil, err
 	}
-	isNullTime, err := regexp.MatchString("NullTime$", kind)
+	onUpdate, err := m.referentialAction(ctx, table, column, values["on_update"])
 	if err != nil {
-		return ""
-	}
-	if isTime || isNullTime {
-		switch m.Driver {
-		case DBDriverPostgres:
-			return "TIMETZ"
-		case DBDriverMySQL:
-			return "DATETIME"
-		}
+		return nil, err
 	}
-	isUuid, err := regexp.MatchString("UUID", kind)
+
+	return &ForeignKey{
+		Name:      foreignKeyName(table, column),
+		Column:    column,
+		RefTable:  match[1],
+		RefColumn: match[2],
+		OnDelete:  onDelete,
+		OnUpdate:  onUpdate,
+	}, nil
+}
+
+// referentialAction returns the referential action of the on_delete or
+// on_update tag of the column
+func (m *Migrator) referentialAction(ctx context.Context, table, column, action string) (string, error) {
+	normalized, err := normalizeReferentialAction(action)
 	if err != nil {
-		return ""
-	}
-	if isUuid {
-		switch m.Driver {
-		case DBDriverPostgres:
-			return "UUID"
-		case DBDriverMySQL:
-			return "binary(16)"
+		err = m.warn(ctx, &MigrationError{Table: table, Column: column, Err: err})
+	}
+
+	return normalized, err
+}
+
+func foreignKeyName(table, column string) string {
+	return fmt.Sprintf("fk_%s_%s", table, column)
+}
+
+// normalizeReferentialAction returns the SQL referential action, RESTRICT is
+// equivalent to the NO ACTION default for non-deferred constraints. Actions
+// which are not valid are replaced by NO ACTION.
+func normalizeReferentialAction(action string) (string, error) {
+	a := strings.ToUpper(strings.TrimSpace(strings.ReplaceAll(action, "_", " ")))
+	switch a {
+	case "CASCADE", "SET NULL", "SET DEFAULT":
+		return a, nil
+	case "", "NO ACTION", "RESTRICT":
+		return "NO ACTION", nil
+	default:
+		return "NO ACTION", fmt.Errorf("%w: referential action %s", ErrInvalidConstraint, action)
+	}
+}
+
+func isTextType(datatype string) bool {
+	d := strings.ToUpper(datatype)
+
+	return strings.Contains(d, "CHAR") || strings.Contains(d, "TEXT")
+}
+
+var sqlTypeSize = regexp.MustCompile(`\((\d+)`)
+
+// integerSizes are the sizes in bytes of the integer datatypes
+var integerSizes = map[string]int{
+	"TINYINT":     1,
+	"SMALLINT":    2,
+	"INT2":        2,
+	"SMALLSERIAL": 2,
+	"MEDIUMINT":   3,
+	"INT":         4,
+	"INTEGER":     4,
+	"INT4":        4,
+	"SERIAL":      4,
+	"BIGINT":      8,
+	"INT8":        8,
+	"BIGSERIAL":   8,
+}
+
+// sqlTypeFamily returns the family of a normalized SQL datatype and its
+// capacity within the family, unbounded types have the largest capacity.
+// Unsigned integers are the uint family.
+func sqlTypeFamily(datatype string) (string, int) {
+	d := strings.ToUpper(datatype)
+	size := math.MaxInt32
+	if match := sqlTypeSize.FindStringSubmatch(d); match != nil {
+		size, _ = strconv.Atoi(match[1])
+	}
+	name, unsigned := strings.CutSuffix(d, " UNSIGNED")
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		// the display width of the MySQL integers
+		name = name[:i]
+	}
+	if integerSize, isInteger := integerSizes[name]; isInteger {
+		if unsigned {
+			return "uint", integerSize
 		}
+		return "int", integerSize
 	}
-	switch kind {
-	case "int":
-		return "INT"
-	case "float":
-		switch m.Driver {
-		case DBDriverPostgres:
-			return "FLOAT8"
-		case DBDriverMySQL:
-			return "FLOAT"
+	switch {
+	case d == "BOOL" || d == "BOOLEAN" || d == "BIT":
+		return "bool", 1
+	case d == "FLOAT4" || d == "REAL" || d == "FLOAT":
+		return "float", 4
+	case d == "FLOAT8" || strings.HasPrefix(d, "DOUBLE"):
+		return "float", 8
+	case d == "DATE":
+		return "date", 0
+	case strings.HasPrefix(d, "TIMESTAMP") || strings.HasPrefix(d, "DATETIME"):
+		return "timestamp", timeTypePrecision(d, size)
+	case strings.HasPrefix(d, "TIME"):
+		return "time", timeTypePrecision(d, size)
+	case strings.Contains(d, "CHAR") || strings.Contains(d, "TEXT"):
+		if strings.HasPrefix(d, "TINYTEXT") {
+			size = 255
 		}
-		return "FLOAT"
-	case "string":
-		return fmt.Sprintf("VARCHAR(%d)", m.DefaultTextSize)
-	case "bool":
-		return "BOOL"
+		return "text", size
+	case strings.Contains(d, "BINARY") || strings.Contains(d, "BLOB") || d == "BYTEA":
+		return "binary", size
 	default:
-		return ""
+		return d, 0
 	}
 }
 
-func convertPostgresSqlType(datatype string) string {
-	if strings.Contains(datatype, "int") {
-		return "INT"
-	} else if strings.Contains(datatype, "float") {
-		return "FLOAT"
-	}
-	switch datatype {
-	case "character varying":
-		return "VARCHAR"
-	case "time with time zone":
-		return "TIMETZ"
-	case "time without time zone":
-		return "TIME"
-	case "text":
-		return "TEXT"
-	case "boolean":
-		return "BOOL"
+// timeTypePrecision returns the precision of the time datatype, size is the
+// precision declared by the datatype
+func timeTypePrecision(datatype string, size int) int {
+	switch {
+	case size != math.MaxInt32:
+		return size
+	case strings.HasPrefix(datatype, "DATETIME2") || strings.HasPrefix(datatype, "DATETIMEOFFSET"):
+		return 7
+	case strings.HasPrefix(datatype, "DATETIME"):
+		return 0
 	default:
-		return ""
+		return 6
 	}
 }
 
-func convertSqlDataType(datatype string) string {
-	d := strings.ToLower(datatype)
-	if strings.Compare(d, "tinyint(1)") == 0 {
-		return "bool"
-	} else {
-		return d
+// dialectTypeFamily is sqlTypeFamily with the capacities which depend on the
+// dialect: FLOAT is a double precision FLOAT(53) in SQL Server
+func dialectTypeFamily(dialect Dialect, datatype string) (string, int) {
+	family, size := sqlTypeFamily(datatype)
+	if dialect.Name() == DBDriverMSSQL.String() && strings.EqualFold(datatype, "FLOAT") {
+		size = 8
 	}
+
+	return family, size
 }
 
-func checkConstraint(constraint string) bool {
-	switch constraint {
-	case "not null":
-		return true
-	case "unique":
-		return true
-	default:
+// isLossyTypeChange reports whether converting a column from a datatype to
+// another may lose data
+func isLossyTypeChange(dialect Dialect, from, to string) bool {
+	fromFamily, fromSize := dialectTypeFamily(dialect, from)
+	toFamily, toSize := dialectTypeFamily(dialect, to)
+	switch {
+	case fromFamily == toFamily:
+		return toSize < fromSize
+	case fromFamily == "uint" && toFamily == "int":
+		// the signed integer must be larger to hold the largest values
+		return toSize <= fromSize
+	case toFamily == "text":
+		// numbers, booleans, dates and uuids are converted to their text representation
+		return fromFamily == "binary" || toSize < 64
+	case fromFamily == "bool" && (toFamily == "int" || toFamily == "uint" || toFamily == "float"):
 		return false
+	case (fromFamily == "int" || fromFamily == "uint") && toFamily == "float":
+		return fromSize > toSize/2
+	case fromFamily == "date" && toFamily == "timestamp":
+		return false
+	default:
+		return true
 	}
 }